@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	standardlog "log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,8 +18,21 @@ import (
 	"github.com/gorilla/mux"
 	cli "github.com/jawher/mow.cli"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 )
 
+const dryRunRecordSize = 1000
+
+// dryRunGroupSuffix is appended to the consumer group in dry run mode, so that a dry run never takes partitions and offsets from the live consumer
+const dryRunGroupSuffix = "-dry-run"
+
+const tracingShutdownTimeout = 5 * time.Second
+
 const appDescription = "Service which listens to kafka for concordance updates, transforms smartlogic concordance json and sends updates to concordances-rw-neo4j"
 
 var httpClient = http.Client{
@@ -73,44 +89,494 @@ func main() {
 		Desc:   "Group name of connection to the Kafka topic",
 		EnvVar: "GROUP_NAME",
 	})
+	concurrency := app.Int(cli.IntOpt{
+		Name:   "concurrency",
+		Value:  1,
+		Desc:   "Number of workers processing Kafka messages in parallel; messages for the same concept are always processed in order by the same worker. Above 1, messages are acknowledged once queued, so those queued when the service dies are lost",
+		EnvVar: "CONCURRENCY",
+	})
+	workerQueueSize := app.Int(cli.IntOpt{
+		Name:   "workerQueueSize",
+		Value:  10,
+		Desc:   "Number of Kafka messages queued per worker before consumption is held up",
+		EnvVar: "WORKER_QUEUE_SIZE",
+	})
 	writerAddress := app.String(cli.StringOpt{
 		Name:   "writerAddress",
 		Desc:   "Concordance rw address for routing requests",
 		EnvVar: "WRITER_ADDRESS",
 	})
+	additionalWriters := app.String(cli.StringOpt{
+		Name:   "additionalWriters",
+		Desc:   "Comma separated writers that concordances are also sent to, in the form name=address, each optionally followed by ;required (the default) or ;best-effort",
+		EnvVar: "ADDITIONAL_WRITERS",
+	})
+	writerMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "writerMaxAttempts",
+		Value:  3,
+		Desc:   "Maximum number of attempts for a write or delete request to the concordance rw; 1 disables retries",
+		EnvVar: "WRITER_MAX_ATTEMPTS",
+	})
+	writerBaseBackoff := app.String(cli.StringOpt{
+		Name:   "writerBaseBackoff",
+		Value:  "100ms",
+		Desc:   "Backoff before the first retry of a request to the concordance rw; doubled on every following retry",
+		EnvVar: "WRITER_BASE_BACKOFF",
+	})
+	writerMaxBackoff := app.String(cli.StringOpt{
+		Name:   "writerMaxBackoff",
+		Value:  "2s",
+		Desc:   "Maximum backoff between retries of a request to the concordance rw",
+		EnvVar: "WRITER_MAX_BACKOFF",
+	})
+	writerBackoffJitter := app.Int(cli.IntOpt{
+		Name:   "writerBackoffJitter",
+		Value:  20,
+		Desc:   "Percentage by which the backoff between retries is randomly spread",
+		EnvVar: "WRITER_BACKOFF_JITTER",
+	})
+	writerRetryStatusCodes := app.Ints(cli.IntsOpt{
+		Name:   "writerRetryStatusCodes",
+		Value:  []int{429, 502, 503, 504},
+		Desc:   "Concordance rw response status codes that are retried",
+		EnvVar: "WRITER_RETRY_STATUS_CODES",
+	})
+	writerRetryTransportErrors := app.Bool(cli.BoolOpt{
+		Name:   "writerRetryTransportErrors",
+		Value:  true,
+		Desc:   "Whether requests to the concordance rw that fail without a response are retried",
+		EnvVar: "WRITER_RETRY_TRANSPORT_ERRORS",
+	})
+	writerTimeout := app.String(cli.StringOpt{
+		Name:   "writerTimeout",
+		Value:  "10s",
+		Desc:   "Deadline for a single call to the concordance rw, after which it is abandoned and classified as a timeout; every retry gets a deadline of its own; 0 disables it",
+		EnvVar: "WRITER_TIMEOUT",
+	})
+	writerAPIKey := app.String(cli.StringOpt{
+		Name:   "writerApiKey",
+		Desc:   "API key sent with every request to the writers, including their __gtg",
+		EnvVar: "WRITER_API_KEY",
+	})
+	writerAPIKeyHeader := app.String(cli.StringOpt{
+		Name:   "writerApiKeyHeader",
+		Value:  "X-Api-Key",
+		Desc:   "Header the writer API key is sent in",
+		EnvVar: "WRITER_API_KEY_HEADER",
+	})
+	writerBasicAuthFile := app.String(cli.StringOpt{
+		Name:   "writerBasicAuthFile",
+		Desc:   "File holding the basic auth credentials, as username:password, sent with every request to the writers",
+		EnvVar: "WRITER_BASIC_AUTH_FILE",
+	})
+	writerTLSCertFile := app.String(cli.StringOpt{
+		Name:   "writerTlsCertFile",
+		Desc:   "PEM client certificate presented to the writers for mTLS",
+		EnvVar: "WRITER_TLS_CERT_FILE",
+	})
+	writerTLSKeyFile := app.String(cli.StringOpt{
+		Name:   "writerTlsKeyFile",
+		Desc:   "PEM key of the client certificate presented to the writers for mTLS",
+		EnvVar: "WRITER_TLS_KEY_FILE",
+	})
+	writerTLSCAFile := app.String(cli.StringOpt{
+		Name:   "writerTlsCaFile",
+		Desc:   "PEM certificates of the CAs the certificates of the writers are verified against, instead of the system roots",
+		EnvVar: "WRITER_TLS_CA_FILE",
+	})
+	writerAuthReloadInterval := app.String(cli.StringOpt{
+		Name:   "writerAuthReloadInterval",
+		Value:  "30s",
+		Desc:   "How often the writer basic auth, certificate and CA files are checked for changes, which are then loaded without a restart; 0 disables reloading",
+		EnvVar: "WRITER_AUTH_RELOAD_INTERVAL",
+	})
+	breakerFailureRate := app.Int(cli.IntOpt{
+		Name:   "breakerFailureRate",
+		Value:  50,
+		Desc:   "Percentage of failed requests to the concordance rw at which the circuit breaker opens and Kafka consumption is paused; 0 disables the circuit breaker",
+		EnvVar: "BREAKER_FAILURE_RATE",
+	})
+	breakerMinRequests := app.Int(cli.IntOpt{
+		Name:   "breakerMinRequests",
+		Value:  10,
+		Desc:   "Minimum number of requests to the concordance rw in the window before the circuit breaker can open",
+		EnvVar: "BREAKER_MIN_REQUESTS",
+	})
+	breakerWindowSize := app.Int(cli.IntOpt{
+		Name:   "breakerWindowSize",
+		Value:  20,
+		Desc:   "Number of most recent requests to the concordance rw the failure rate is calculated over",
+		EnvVar: "BREAKER_WINDOW_SIZE",
+	})
+	breakerOpenDuration := app.String(cli.StringOpt{
+		Name:   "breakerOpenDuration",
+		Value:  "30s",
+		Desc:   "Time the circuit breaker stays open before probing the concordance rw __gtg",
+		EnvVar: "BREAKER_OPEN_DURATION",
+	})
+	writeCacheSize := app.Int(cli.IntOpt{
+		Name:   "writeCacheSize",
+		Value:  10000,
+		Desc:   "Number of concepts whose last written concordance is remembered so that unchanged concordances are not written again; 0 disables the cache",
+		EnvVar: "WRITE_CACHE_SIZE",
+	})
+	writeCacheTTL := app.String(cli.StringOpt{
+		Name:   "writeCacheTTL",
+		Value:  "1h",
+		Desc:   "Time after which a remembered concordance is written again even if unchanged; 0 keeps it until evicted",
+		EnvVar: "WRITE_CACHE_TTL",
+	})
+	debounceWindow := app.String(cli.StringOpt{
+		Name:   "debounceWindow",
+		Value:  "0",
+		Desc:   "Time the write of a concept consumed from Kafka is held back after the first update of a burst, so that only the latest update of the burst is written; 0 disables debouncing",
+		EnvVar: "DEBOUNCE_WINDOW",
+	})
+	dryRun := app.Bool(cli.BoolOpt{
+		Name:   "dryRun",
+		Value:  false,
+		Desc:   "Consume and transform messages as normal, with the consumer group suffixed by -dry-run, but only log and record the requests that would have been sent to the concordance rw",
+		EnvVar: "DRY_RUN",
+	})
+	outputSink := app.String(cli.StringOpt{
+		Name:   "outputSink",
+		Value:  "http",
+		Desc:   "Where concordances are sent: http to the concordance rw, or kafka to the concordance topic",
+		EnvVar: "OUTPUT_SINK",
+	})
+	concordanceTopic := app.String(cli.StringOpt{
+		Name:   "concordanceTopic",
+		Value:  "Concordances",
+		Desc:   "Kafka topic concordances are published to, keyed by concept uuid, when outputSink is kafka",
+		EnvVar: "CONCORDANCE_TOPIC",
+	})
+	kafkaAddress := app.String(cli.StringOpt{
+		Name:   "kafkaAddress",
+		Desc:   "Kafka broker address(es) used to publish to the dead-letter and concordance topics, in the form host1:9092,host2:9092",
+		EnvVar: "KAFKA_ADDRESS",
+	})
+	deadLetterTopic := app.String(cli.StringOpt{
+		Name:   "deadLetterTopic",
+		Value:  "",
+		Desc:   "Kafka topic that messages failing transformation or writing are published to; disabled when empty",
+		EnvVar: "DEAD_LETTER_TOPIC",
+	})
+	auditLog := app.String(cli.StringOpt{
+		Name:   "auditLog",
+		Value:  "none",
+		Desc:   "Where every write and delete of a concordance is recorded: none, file for a rotating local JSONL file, or kafka for the audit topic",
+		EnvVar: "AUDIT_LOG",
+	})
+	auditFile := app.String(cli.StringOpt{
+		Name:   "auditFile",
+		Value:  "audit/concordances.jsonl",
+		Desc:   "JSONL file audit records are appended to when auditLog is file",
+		EnvVar: "AUDIT_FILE",
+	})
+	auditMaxFileSize := app.Int(cli.IntOpt{
+		Name:   "auditMaxFileSize",
+		Value:  100,
+		Desc:   "Size in megabytes beyond which the audit file is rotated",
+		EnvVar: "AUDIT_MAX_FILE_SIZE",
+	})
+	auditMaxFiles := app.Int(cli.IntOpt{
+		Name:   "auditMaxFiles",
+		Value:  10,
+		Desc:   "Number of rotated audit files kept",
+		EnvVar: "AUDIT_MAX_FILES",
+	})
+	auditTopic := app.String(cli.StringOpt{
+		Name:   "auditTopic",
+		Value:  "ConcordanceAudit",
+		Desc:   "Kafka topic audit records are published to, keyed by concept uuid, when auditLog is kafka",
+		EnvVar: "AUDIT_TOPIC",
+	})
+	auditHistorySize := app.Int(cli.IntOpt{
+		Name:   "auditHistorySize",
+		Value:  10000,
+		Desc:   "Number of concepts whose most recent audit records are kept in memory, to record the concordance they had before and to answer /__audit when auditLog is kafka",
+		EnvVar: "AUDIT_HISTORY_SIZE",
+	})
+	apiCredentialsFile := app.String(cli.StringOpt{
+		Name:   "apiCredentialsFile",
+		Desc:   "JSON file listing the principals allowed to call the API, each with a name, an apiKey and/or a bearer token, and roles (read, write); /transform/send requires the write role. The API is open when empty",
+		EnvVar: "API_CREDENTIALS_FILE",
+	})
+	apiKeyHeader := app.String(cli.StringOpt{
+		Name:   "apiKeyHeader",
+		Value:  "X-Api-Key",
+		Desc:   "Header clients send their API key in",
+		EnvVar: "API_KEY_HEADER",
+	})
+	shutdownTimeout := app.String(cli.StringOpt{
+		Name:   "shutdownTimeout",
+		Value:  "30s",
+		Desc:   "Time allowed on shutdown for in-flight messages and requests to finish before the service stops regardless",
+		EnvVar: "SHUTDOWN_TIMEOUT",
+	})
+	tracingExporter := app.String(cli.StringOpt{
+		Name:   "tracingExporter",
+		Value:  "none",
+		Desc:   "Where trace spans are exported to: none, stdout for local use, or otlp",
+		EnvVar: "TRACING_EXPORTER",
+	})
+	otlpEndpoint := app.String(cli.StringOpt{
+		Name:   "otlpEndpoint",
+		Value:  "localhost:4318",
+		Desc:   "host:port of the OTLP/HTTP collector trace spans are exported to when tracingExporter is otlp",
+		EnvVar: "OTLP_ENDPOINT",
+	})
+	otlpInsecure := app.Bool(cli.BoolOpt{
+		Name:   "otlpInsecure",
+		Value:  false,
+		Desc:   "Whether trace spans are exported to the OTLP collector over plain HTTP instead of HTTPS",
+		EnvVar: "OTLP_INSECURE",
+	})
 
-	app.Action = func() {
-		lvl, err := log.ParseLevel(*logLevel)
+	// newConcordanceProducer returns nil unless concordances are published to Kafka, which they are not in dry run mode
+	newConcordanceProducer := func() slc.ConcordanceProducer {
+		switch {
+		case *outputSink == "http" || *outputSink == "kafka" && *dryRun:
+			return nil
+		case *outputSink == "kafka":
+			producer, err := slc.NewConcordanceProducer(*kafkaAddress, *concordanceTopic)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot create Kafka concordance producer")
+			}
+			return producer
+		default:
+			log.Fatalf("Unknown output sink %q; expected http or kafka", *outputSink)
+			return nil
+		}
+	}
+
+	// newAuditWriter returns nil unless concordance writes are audited, which they are not in dry run mode
+	newAuditWriter := func() slc.AuditWriter {
+		switch {
+		case *auditLog == "none" || *dryRun:
+			return nil
+		case *auditLog == "file":
+			writer, err := slc.NewAuditFile(*auditFile, int64(*auditMaxFileSize)*1024*1024, *auditMaxFiles)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot open audit file")
+			}
+			return writer
+		case *auditLog == "kafka":
+			producer, err := slc.NewConcordanceProducer(*kafkaAddress, *auditTopic)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot create Kafka audit producer")
+			}
+			return slc.NewKafkaAuditWriter(producer)
+		default:
+			log.Fatalf("Unknown audit log %q; expected none, file or kafka", *auditLog)
+			return nil
+		}
+	}
+
+	writerAuthConfig := func() slc.WriterAuthConfig {
+		return slc.WriterAuthConfig{
+			APIKeyHeader:   *writerAPIKeyHeader,
+			APIKey:         *writerAPIKey,
+			BasicAuthFile:  *writerBasicAuthFile,
+			ClientCertFile: *writerTLSCertFile,
+			ClientKeyFile:  *writerTLSKeyFile,
+			CAFile:         *writerTLSCAFile,
+			ReloadInterval: parseDuration("writerAuthReloadInterval", *writerAuthReloadInterval),
+		}
+	}
+
+	// newWriterClient authenticates the requests to the writers, which are sent unauthenticated when no credentials are configured
+	newWriterClient := func() *slc.WriterAuthClient {
+		client, err := slc.NewWriterAuthClient(&httpClient, writerAuthConfig())
 		if err != nil {
-			log.Fatalf("Cannot parse log level: %s", *logLevel)
+			log.WithError(err).Fatal("Cannot load the writer credentials")
 		}
-		log.SetLevel(lvl)
-		log.SetFormatter(&log.JSONFormatter{})
+		return client
+	}
 
+	writerOptions := func(producer slc.ConcordanceProducer, auditWriter slc.AuditWriter) []slc.TransformerOption {
+		retryPolicy := slc.RetryPolicy{
+			MaxAttempts:          *writerMaxAttempts,
+			BaseBackoff:          parseDuration("writerBaseBackoff", *writerBaseBackoff),
+			MaxBackoff:           parseDuration("writerMaxBackoff", *writerMaxBackoff),
+			Jitter:               float64(*writerBackoffJitter) / 100,
+			RetryableStatusCodes: *writerRetryStatusCodes,
+			RetryTransportErrors: *writerRetryTransportErrors,
+		}
+		opts := []slc.TransformerOption{
+			slc.WithRetryPolicy(retryPolicy),
+			slc.WithWriterTimeout(parseDuration("writerTimeout", *writerTimeout)),
+		}
+		if *dryRun {
+			opts = append(opts, slc.WithDryRun(dryRunRecordSize))
+		}
+		if producer != nil {
+			opts = append(opts, slc.WithKafkaSink(producer, *concordanceTopic))
+		}
+		if auditWriter != nil {
+			opts = append(opts, slc.WithAuditLog(auditWriter, *auditHistorySize))
+		}
+		writers, err := slc.ParseWriters(*additionalWriters)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid ADDITIONAL_WRITERS")
+		}
+		if len(writers) > 0 {
+			opts = append(opts, slc.WithAdditionalWriters(writers))
+		}
+		return opts
+	}
+
+	app.Command("replay", "Re-drive concordances to the concordance rw from Smartlogic JSON-LD exports, e.g. after a neo4j restore", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--concurrency] [--rateLimit] [PATHS...]"
+		concurrency := cmd.Int(cli.IntOpt{
+			Name:   "concurrency",
+			Value:  4,
+			Desc:   "Number of concepts written in parallel; the documents of a concept are always written in the order they are read",
+			EnvVar: "REPLAY_CONCURRENCY",
+		})
+		rateLimit := cmd.Int(cli.IntOpt{
+			Name:   "rateLimit",
+			Value:  0,
+			Desc:   "Maximum number of requests per second to the concordance rw; 0 leaves them unlimited",
+			EnvVar: "REPLAY_RATE_LIMIT",
+		})
+		paths := cmd.Strings(cli.StringsArg{
+			Name: "PATHS",
+			Desc: "Files or directories of JSON-LD documents, or - for stdin, which is also read when no path is given",
+		})
+
+		cmd.Action = func() {
+			configureLogging(*logLevel)
+			if *writerAddress == "" && *outputSink == "http" && !*dryRun {
+				log.Fatal("WRITER_ADDRESS is required to replay concordances")
+			}
+			sources, err := slc.ReplaySources(*paths, os.Stdin)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot read replay sources")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				waitForSignal()
+				log.Warn("Replay interrupted; aborting the writes in flight")
+				cancel()
+			}()
+
+			log.WithFields(log.Fields{"WRITER_ADDRESS": *writerAddress, "WRITER_AUTH": strings.Join(writerAuthConfig().Mechanisms(), ","), "OUTPUT_SINK": *outputSink, "AUDIT_LOG": *auditLog, "sources": len(sources), "DRY_RUN": *dryRun}).Info("Replaying Smartlogic documents")
+			producer := newConcordanceProducer()
+			auditWriter := newAuditWriter()
+			transformer := slc.NewTransformerService(*topic, *writerAddress, newWriterClient(), writerOptions(producer, auditWriter)...)
+			summary := transformer.Replay(ctx, sources, slc.ReplayConfig{Concurrency: *concurrency, RateLimit: float64(*rateLimit)})
+			if producer != nil {
+				producer.Shutdown()
+			}
+			if auditWriter != nil {
+				if err := auditWriter.Close(); err != nil {
+					log.WithError(err).Warn("Error closing the audit log")
+				}
+			}
+			log.WithFields(log.Fields{"documents": summary.Documents, "written": summary.Written, "deleted": summary.Deleted, "skipped": summary.Skipped, "failed": summary.Failed}).Info("Replay finished")
+			summary.WriteReport(os.Stdout)
+			if summary.Failed > 0 || ctx.Err() != nil {
+				cli.Exit(1)
+			}
+		}
+	})
+
+	app.Action = func() {
+		configureLogging(*logLevel)
+
+		consumerGroup := *groupName
+		if *dryRun && !strings.HasSuffix(consumerGroup, dryRunGroupSuffix) {
+			consumerGroup += dryRunGroupSuffix
+		}
 		log.WithFields(log.Fields{
 			"WRITER_ADDRESS":           *writerAddress,
+			"ADDITIONAL_WRITERS":       *additionalWriters,
 			"KAFKA_TOPIC":              *topic,
-			"GROUP_NAME":               *groupName,
+			"GROUP_NAME":               consumerGroup,
 			"BROKER_CONNECTION_STRING": *brokerConnectionString,
+			"KAFKA_ADDRESS":            *kafkaAddress,
+			"DEAD_LETTER_TOPIC":        *deadLetterTopic,
+			"OUTPUT_SINK":              *outputSink,
+			"AUDIT_LOG":                *auditLog,
+			"WRITER_AUTH":              strings.Join(writerAuthConfig().Mechanisms(), ","),
+			"API_CREDENTIALS_FILE":     *apiCredentialsFile,
+			"DRY_RUN":                  *dryRun,
+			"CONCURRENCY":              *concurrency,
+			"TRACING_EXPORTER":         *tracingExporter,
 		}).Infof("[Startup] smartlogic-concordance-transformer is starting")
 
 		log.Infof("System code: %s, App Name: %s, Port: %s", *appSystemCode, *appName, *port)
 
+		tracerProvider, err := newTracerProvider(*tracingExporter, *otlpEndpoint, *otlpInsecure, *appSystemCode)
+		if err != nil {
+			log.WithError(err).Fatal("Cannot create trace exporter")
+		}
+		if tracerProvider != nil {
+			otel.SetTracerProvider(tracerProvider)
+		}
+
 		consumerConfig := kafka.DefaultConsumerConfig()
 		consumerConfig.Zookeeper.Logger = standardlog.New(ioutil.Discard, "", 0)
-		consumer, err := kafka.NewPerseverantConsumer(*brokerConnectionString, *groupName, []string{*topic}, consumerConfig, time.Minute, nil)
+		consumer, err := kafka.NewPerseverantConsumer(*brokerConnectionString, consumerGroup, []string{*topic}, consumerConfig, time.Minute, nil)
 		if err != nil {
 			log.WithError(err).Fatal("Cannot create Kafka client")
 		}
 
+		if *dryRun {
+			log.Warn("[Startup] Running in dry run mode: no requests will be sent to the concordance rw and no messages to the dead-letter topic")
+		}
+
+		var deadLetterProducer kafka.Producer
+		if *deadLetterTopic != "" && !*dryRun {
+			deadLetterProducer, err = kafka.NewPerseverantProducer(*kafkaAddress, *deadLetterTopic, nil, 0, time.Minute)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot create Kafka dead-letter producer")
+			}
+		}
+
+		concordanceProducer := newConcordanceProducer()
+		auditWriter := newAuditWriter()
+		transformerOpts := writerOptions(concordanceProducer, auditWriter)
+		if *writeCacheSize > 0 {
+			transformerOpts = append(transformerOpts, slc.WithWriteCache(*writeCacheSize, parseDuration("writeCacheTTL", *writeCacheTTL)))
+		}
+		if window := parseDuration("debounceWindow", *debounceWindow); window > 0 {
+			transformerOpts = append(transformerOpts, slc.WithDebounce(window))
+		}
+		if *breakerFailureRate > 0 {
+			transformerOpts = append(transformerOpts, slc.WithCircuitBreaker(slc.CircuitBreakerConfig{
+				FailureRate:  float64(*breakerFailureRate) / 100,
+				MinRequests:  *breakerMinRequests,
+				WindowSize:   *breakerWindowSize,
+				OpenDuration: parseDuration("breakerOpenDuration", *breakerOpenDuration),
+			}))
+		}
+
 		router := mux.NewRouter()
-		transformer := slc.NewTransformerService(*topic, *writerAddress, &httpClient)
-		handler := slc.NewHandler(transformer, consumer)
+		transformer := slc.NewTransformerService(*topic, *writerAddress, newWriterClient(), transformerOpts...)
+		handlerOpts := []slc.HandlerOption{slc.WithConcurrency(*concurrency, *workerQueueSize)}
+		if *apiCredentialsFile != "" {
+			auth, err := slc.LoadAuthenticator(*apiCredentialsFile, *apiKeyHeader)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot load API credentials")
+			}
+			handlerOpts = append(handlerOpts, slc.WithAuthenticator(auth))
+		} else {
+			log.Warn("API_CREDENTIALS_FILE is not set; /transform/send is open to anyone who can reach the service")
+		}
+		handler := slc.NewHandler(transformer, consumer, deadLetterProducer, handlerOpts...)
 		handler.RegisterHandlers(router)
 		handler.RegisterAdminHandlers(router, *appSystemCode, *appName, appDescription)
 
+		drainTimeout := parseDuration("shutdownTimeout", *shutdownTimeout)
+		server := &http.Server{
+			Addr:        ":" + *port,
+			BaseContext: func(net.Listener) context.Context { return handler.BaseContext() },
+		}
 		go func() {
-			if err := http.ListenAndServe(":"+*port, nil); err != nil {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 				log.WithError(err).Fatal("Unable to start server")
 			}
 		}()
@@ -118,9 +584,40 @@ func main() {
 		consumer.StartListening(handler.ProcessKafkaMessage)
 
 		waitForSignal()
-		log.Info("Shutting down Kafka consumer")
+		log.WithField("SHUTDOWN_TIMEOUT", drainTimeout.String()).Info("[Shutdown] smartlogic-concordance-transformer is shutting down")
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancelShutdown()
+		handler.StartDraining()
+		log.Info("[Shutdown] Shutting down Kafka consumer")
 		consumer.Shutdown()
-		log.Info("Stopping application")
+		handler.AwaitDrained(shutdownCtx)
+		log.Info("[Shutdown] Shutting down HTTP server")
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Warn("[Shutdown] HTTP server did not shut down gracefully")
+		}
+		if concordanceProducer != nil {
+			log.Info("[Shutdown] Shutting down Kafka concordance producer")
+			concordanceProducer.Shutdown()
+		}
+		if deadLetterProducer != nil {
+			log.Info("[Shutdown] Shutting down Kafka dead-letter producer")
+			deadLetterProducer.Shutdown()
+		}
+		if auditWriter != nil {
+			log.Info("[Shutdown] Closing the audit log")
+			if err := auditWriter.Close(); err != nil {
+				log.WithError(err).Warn("[Shutdown] Error closing the audit log")
+			}
+		}
+		if tracerProvider != nil {
+			log.Info("[Shutdown] Flushing trace spans")
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			if err := tracerProvider.Shutdown(ctx); err != nil {
+				log.WithError(err).Warn("[Shutdown] Trace spans could not be flushed")
+			}
+			cancel()
+		}
+		log.Info("[Shutdown] Stopping application")
 	}
 
 	runErr := app.Run(os.Args)
@@ -130,6 +627,51 @@ func main() {
 	}
 }
 
+func configureLogging(logLevel string) {
+	lvl, err := log.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatalf("Cannot parse log level: %s", logLevel)
+	}
+	log.SetLevel(lvl)
+	log.SetFormatter(&log.JSONFormatter{})
+}
+
+func parseDuration(name string, value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot parse %s: %s", name, value)
+	}
+	return d
+}
+
+// newTracerProvider returns nil when tracing is disabled, leaving the default no-op tracer provider in place
+func newTracerProvider(exporterName string, otlpEndpoint string, otlpInsecure bool, serviceName string) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(otlpEndpoint)}
+		if otlpInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q; expected none, stdout or otlp", exporterName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	), nil
+}
+
 func waitForSignal() {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,298 @@
+package smartlogic
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	auditMessageType = "concordance-audit"
+	// auditRecordsPerConcept is how many of the most recent records of a concept are kept in memory and returned by /__audit
+	auditRecordsPerConcept = 20
+)
+
+// AuditRecord is an entry of the audit log: a PUT or DELETE of the concordance of a concept issued to the writers
+type AuditRecord struct {
+	Time          time.Time   `json:"time"`
+	TransactionID string      `json:"transactionId"`
+	ConceptUuid   string      `json:"uuid"`
+	Method        string      `json:"method"`
+	Source        AuditSource `json:"source"`
+	// Before is null when what the writers held for the concept is not known, which is the case when the service has not
+	// written the concept since it started or the last write of the concept failed
+	Before []ConcordedId `json:"before"`
+	After  []ConcordedId `json:"after"`
+	Status string        `json:"status"`
+	Error  string        `json:"error,omitempty"`
+	// Writers is the result of this write at each writer, when there are additional writers
+	Writers []writerOutcomeResponse `json:"writers,omitempty"`
+}
+
+// AuditSource tells what caused a concordance to be written. The FT Kafka client does not expose the partition and offset
+// of consumed messages, so Smartlogic messages are identified by their topic and Message-Id.
+type AuditSource struct {
+	// Type is kafka, http or replay
+	Type       string `json:"type"`
+	Topic      string `json:"topic,omitempty"`
+	MessageID  string `json:"messageId,omitempty"`
+	Principal  string `json:"principal,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	File       string `json:"file,omitempty"`
+	Document   int    `json:"document,omitempty"`
+}
+
+type auditSourceKey struct{}
+
+// withAuditSource returns a context under which the concordances written are audited as caused by source
+func withAuditSource(ctx context.Context, source AuditSource) context.Context {
+	return context.WithValue(ctx, auditSourceKey{}, source)
+}
+
+func auditSourceFrom(ctx context.Context) AuditSource {
+	source, _ := ctx.Value(auditSourceKey{}).(AuditSource)
+	return source
+}
+
+// AuditWriter appends records to the audit log
+type AuditWriter interface {
+	Write(record AuditRecord) error
+	Close() error
+}
+
+// auditReader is implemented by the audit writers that can read the records they wrote back
+type auditReader interface {
+	// read returns the most recent records of a concept, up to limit, oldest first
+	read(uuid string, limit int) ([]AuditRecord, error)
+	// scan passes every record kept, oldest first, to fn
+	scan(fn func(AuditRecord)) error
+}
+
+// auditLog writes a record of every PUT and DELETE of a concordance and remembers the most recent records of the most recently
+// written concepts, which tell the concordance each concept had before it was written and answer queries for the writers that cannot read back
+type auditLog struct {
+	sync.Mutex
+	writer  AuditWriter
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+	// seeded is closed once the records of a writer that can read them back have been remembered
+	seeded chan struct{}
+}
+
+type auditLogEntry struct {
+	uuid    string
+	records []AuditRecord
+}
+
+// WithAuditLog records every PUT and DELETE of a concordance with writer, keeping the most recent records of up to historySize concepts in memory.
+// When the writer can read its records back, they are remembered in the background, so that the concordance a concept had before is known
+// after a restart without holding up the start of the service.
+func WithAuditLog(writer AuditWriter, historySize int) TransformerOption {
+	return func(ts *TransformerService) {
+		if historySize < 1 {
+			historySize = 1
+		}
+		ts.audit = newAuditLog(writer, historySize)
+		if reader, ok := writer.(auditReader); ok {
+			go ts.audit.seed(reader)
+		} else {
+			close(ts.audit.seeded)
+		}
+	}
+}
+
+func newAuditLog(writer AuditWriter, size int) *auditLog {
+	return &auditLog{writer: writer, size: size, entries: map[string]*list.Element{}, order: list.New(), now: time.Now, seeded: make(chan struct{})}
+}
+
+// seed remembers the records kept by reader behind those written since the service started
+func (a *auditLog) seed(reader auditReader) {
+	defer close(a.seeded)
+	seeded := newAuditLog(nil, a.size)
+	records := 0
+	err := reader.scan(func(record AuditRecord) {
+		seeded.keep(record)
+		records++
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to read the audit log back; what concepts written before the service started had before their next write will not be known")
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	for element := seeded.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*auditLogEntry)
+		if live, found := a.entries[entry.uuid]; found {
+			liveEntry := live.Value.(*auditLogEntry)
+			liveEntry.records = lastAuditRecords(append(entry.records, liveEntry.records...))
+		} else if a.order.Len() < a.size {
+			a.entries[entry.uuid] = a.order.PushBack(entry)
+		}
+	}
+	log.WithFields(log.Fields{"records": records, "concepts": a.order.Len()}).Info("Read the audit log back")
+}
+
+// record audits a request made for the concordance of a concept. A record that cannot be written is logged and counted, but does not
+// fail the concordance, which has already been sent.
+func (a *auditLog) record(ctx context.Context, uuid string, method string, uppConcordance UppConcordance, tid string, reqStatus status, outcomes []WriterOutcome, err error) {
+	if a == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:          a.now().UTC(),
+		TransactionID: tid,
+		ConceptUuid:   uuid,
+		Method:        method,
+		Source:        auditSourceFrom(ctx),
+		After:         append([]ConcordedId{}, uppConcordance.ConcordedIds...),
+		Status:        reqStatus.String(),
+		Writers:       newWriterOutcomeResponses(outcomes),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	// The record is written while holding the lock, so that records reach the writer in the order their Before was worked out in
+	a.Lock()
+	defer a.Unlock()
+	record.Before = a.remember(record)
+	err = a.writer.Write(record)
+	observeAuditRecord(err)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "method": method}).Error("Failed to write audit record")
+	}
+}
+
+// remember keeps the record in memory and returns the concordance the concept had before, or nil when it is not known; the lock must be held
+func (a *auditLog) remember(record AuditRecord) []ConcordedId {
+	var before []ConcordedId
+	if element, found := a.entries[record.ConceptUuid]; found {
+		records := element.Value.(*auditLogEntry).records
+		if n := len(records); n > 0 && records[n-1].Error == "" {
+			before = records[n-1].After
+		}
+	}
+	record.Before = before
+	a.keep(record)
+	return before
+}
+
+// keep appends the record to those of its concept, evicting the least recently written concept when full; the lock must be held
+func (a *auditLog) keep(record AuditRecord) {
+	element, found := a.entries[record.ConceptUuid]
+	if !found {
+		element = a.order.PushFront(&auditLogEntry{uuid: record.ConceptUuid})
+		a.entries[record.ConceptUuid] = element
+		if a.order.Len() > a.size {
+			oldest := a.order.Back()
+			a.order.Remove(oldest)
+			delete(a.entries, oldest.Value.(*auditLogEntry).uuid)
+		}
+	}
+	entry := element.Value.(*auditLogEntry)
+	entry.records = lastAuditRecords(append(entry.records, record))
+	a.order.MoveToFront(element)
+}
+
+// lastAuditRecords returns the most recent auditRecordsPerConcept of records
+func lastAuditRecords(records []AuditRecord) []AuditRecord {
+	if len(records) > auditRecordsPerConcept {
+		return records[len(records)-auditRecordsPerConcept:]
+	}
+	return records
+}
+
+// history returns the most recent records of a concept, oldest first: read back from the writer if it can, so that concepts
+// evicted from memory are found too, otherwise those kept in memory
+func (a *auditLog) history(uuid string) ([]AuditRecord, error) {
+	if reader, ok := a.writer.(auditReader); ok {
+		return reader.read(uuid, auditRecordsPerConcept)
+	}
+	a.Lock()
+	defer a.Unlock()
+	element, found := a.entries[uuid]
+	if !found {
+		return nil, nil
+	}
+	records := element.Value.(*auditLogEntry).records
+	return append([]AuditRecord{}, records...), nil
+}
+
+// kafkaWriter returns the writer of the audit log if it publishes to Kafka
+func (a *auditLog) kafkaWriter() (*kafkaAuditWriter, bool) {
+	if a == nil {
+		return nil, false
+	}
+	writer, ok := a.writer.(*kafkaAuditWriter)
+	return writer, ok
+}
+
+// kafkaAuditWriter publishes audit records to a Kafka topic under the uuid of the concept as message key
+type kafkaAuditWriter struct {
+	producer ConcordanceProducer
+}
+
+// NewKafkaAuditWriter publishes audit records to the topic of producer
+func NewKafkaAuditWriter(producer ConcordanceProducer) AuditWriter {
+	return &kafkaAuditWriter{producer: producer}
+}
+
+func (w *kafkaAuditWriter) Write(record AuditRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"X-Request-Id":         record.TransactionID,
+		"Message-Id":           uuid.NewRandom().String(),
+		messageTypeHeader:      auditMessageType,
+		"Origin-System-Id":     concordanceOriginCode,
+		messageTimestampHeader: record.Time.Format(messageTimestampFormat),
+		"Content-Type":         "application/json",
+	}
+	return w.producer.SendMessage(record.ConceptUuid, kafka.NewFTMessage(headers, string(body)))
+}
+
+func (w *kafkaAuditWriter) Close() error {
+	w.producer.Shutdown()
+	return nil
+}
+
+func (w *kafkaAuditWriter) checkConnectivity() (string, error) {
+	if err := w.producer.ConnectivityCheck(); err != nil {
+		log.WithError(err).Error("Error verifying open connection to the audit topic")
+		return "Error connecting to the audit topic", err
+	}
+	return "Successfully connected to the audit topic", nil
+}
+
+// AuditHandler returns the audit records of the concept, oldest first
+func (h *SmartlogicConcordanceTransformerHandler) AuditHandler(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	conceptUuid := mux.Vars(req)["uuid"]
+	if !uuidMatcher.MatchString(conceptUuid) {
+		writeJSONError(rw, "Invalid concept uuid: "+conceptUuid, http.StatusBadRequest)
+		return
+	}
+	records, err := h.transformer.audit.history(conceptUuid)
+	if err != nil {
+		log.WithError(err).WithField("UUID", conceptUuid).Error("Failed to read audit records")
+		writeJSONError(rw, "Failed to read audit records: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		writeJSONError(rw, "No audit records found for concept "+conceptUuid, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(rw).Encode(records)
+}
@@ -0,0 +1,244 @@
+package smartlogic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	auditPath := filepath.Join(dir, "audit", "concordances.jsonl")
+	auditWriter, err := NewAuditFile(auditPath, 1024*1024, 2)
+	assert.NoError(t, err)
+	defer auditWriter.Close()
+
+	auth, err := NewAuthenticator(testPrincipals, "")
+	assert.NoError(t, err)
+	// Writes succeed with 200 but deletes fail, as they expect 204 or 404
+	mockClient := mockHttpClient{resp: "", statusCode: 200}
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient, WithAuditLog(auditWriter, 10)), mockConsumer{}, nil, WithAuthenticator(auth))
+	r := mux.NewRouter()
+	h.RegisterHandlers(r)
+	r.Path("/__audit/{uuid}").Handler(h.requireRole(RoleRead, http.HandlerFunc(h.AuditHandler)))
+
+	assert.NoError(t, h.ProcessKafkaMessage(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_kafka", "Message-Id": "message-1"}, readFile(t, "../resources/multipleTmeIds.json"))))
+	for _, body := range []string{"noTmeIds.json", "multipleTmeIds.json"} {
+		req := newRequest("POST", "/transform/send", readFile(t, "../resources/"+body))
+		req.Header.Set("X-Request-Id", "tid_"+body)
+		req.Header.Set("X-Api-Key", "publisher-key")
+		req.RemoteAddr = "10.0.0.1:1234"
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// A concordance that is not sent is not audited
+	r.ServeHTTP(httptest.NewRecorder(), newRequest("POST", "/transform/send", readFile(t, "../resources/multipleTmeIds.json")))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", "/__audit/"+testUuid, ""))
+	assert.Equal(t, 401, rec.Code, "The audit log should not be readable without credentials")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, auditRequest(testUuid, "writer-key"))
+	assert.Equal(t, 403, rec.Code, "The audit log should only be readable with the read role")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, auditRequest(testUuid, "support-key"))
+	assert.Equal(t, 200, rec.Code)
+	var records []AuditRecord
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&records))
+	if !assert.Len(t, records, 3) {
+		return
+	}
+	tmeIds := records[0].After
+	assert.Len(t, tmeIds, 4)
+
+	assert.Equal(t, "tid_kafka", records[0].TransactionID)
+	assert.Equal(t, "PUT", records[0].Method)
+	assert.Equal(t, AuditSource{Type: "kafka", Topic: TOPIC, MessageID: "message-1"}, records[0].Source)
+	assert.Nil(t, records[0].Before, "What the writer held is not known before the first write of the concept")
+	assert.Equal(t, VALID_CONCEPT.String(), records[0].Status)
+	assert.Empty(t, records[0].Error)
+
+	assert.Equal(t, "tid_noTmeIds.json", records[1].TransactionID)
+	assert.Equal(t, "DELETE", records[1].Method)
+	assert.Equal(t, AuditSource{Type: "http", Principal: "publisher", RemoteAddr: "10.0.0.1:1234"}, records[1].Source)
+	assert.Equal(t, tmeIds, records[1].Before)
+	assert.Equal(t, []ConcordedId{}, records[1].After)
+	assert.Equal(t, INTERNAL_ERROR.String(), records[1].Status)
+	assert.Contains(t, records[1].Error, "unexpected status: 200")
+
+	assert.Equal(t, "tid_multipleTmeIds.json", records[2].TransactionID)
+	assert.Nil(t, records[2].Before, "What the writer held is not known after a failed request")
+	assert.Equal(t, tmeIds, records[2].After)
+
+	content, err := ioutil.ReadFile(auditPath)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(content)), "\n"), 3, "Every request should be appended to the audit file as a line")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, auditRequest("d8e1c8c8-5b3a-4b8e-9a6b-6d5b0b8c4f11", "support-key"))
+	assert.Equal(t, 404, rec.Code)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, auditRequest("not-a-uuid", "support-key"))
+	assert.Equal(t, 400, rec.Code)
+}
+
+func auditRequest(uuid string, apiKey string) *http.Request {
+	req := newRequest("GET", "/__audit/"+uuid, "")
+	req.Header.Set("X-Api-Key", apiKey)
+	return req
+}
+
+func TestAuditLogAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	auditPath := filepath.Join(dir, "concordances.jsonl")
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+	concordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{{Authority: "TME", AuthorityValue: "tme-1", UUID: "e9f4525a-401f-3b23-a68e-e48f314fdce6"}}}
+
+	auditWriter, err := NewAuditFile(auditPath, 1024*1024, 2)
+	assert.NoError(t, err)
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, &recordingHttpClient{}, WithAuditLog(auditWriter, 10))
+	<-ts.audit.seeded
+	_, _, err = ts.makeRelevantRequest(context.Background(), testUuid, concordance, "tid_before_restart")
+	assert.NoError(t, err)
+	assert.NoError(t, auditWriter.Close())
+
+	auditWriter, err = NewAuditFile(auditPath, 1024*1024, 2)
+	assert.NoError(t, err)
+	defer auditWriter.Close()
+	ts = NewTransformerService(TOPIC, WRITER_ADDRESS, &recordingHttpClient{statusCodes: []int{204}}, WithAuditLog(auditWriter, 10))
+	<-ts.audit.seeded
+	_, _, err = ts.makeRelevantRequest(context.Background(), testUuid, noConcordance, "tid_after_restart")
+	assert.NoError(t, err)
+
+	records, err := ts.audit.history(testUuid)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "tid_after_restart", records[1].TransactionID)
+		assert.Equal(t, concordance.ConcordedIds, records[1].Before, "What the writer held should be read back from the audit file after a restart")
+	}
+}
+
+func TestAuditFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	auditPath := filepath.Join(dir, "concordances.jsonl")
+	writer, err := NewAuditFile(auditPath, 400, 2)
+	assert.NoError(t, err)
+	defer writer.Close()
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, writer.Write(AuditRecord{ConceptUuid: testUuid, TransactionID: fmt.Sprintf("tid_%d", i), Method: "PUT", Source: AuditSource{Type: "kafka", Topic: TOPIC}}))
+		assert.NoError(t, writer.Write(AuditRecord{ConceptUuid: "d8e1c8c8-5b3a-4b8e-9a6b-6d5b0b8c4f11", TransactionID: fmt.Sprintf("tid_other_%d", i), Method: "DELETE"}))
+	}
+	for _, file := range []string{auditPath, auditPath + ".1", auditPath + ".2"} {
+		info, err := os.Stat(file)
+		if assert.NoError(t, err) {
+			assert.True(t, info.Size() <= 400, "The audit file should be rotated before growing beyond its maximum size")
+		}
+	}
+	_, err = os.Stat(auditPath + ".3")
+	assert.True(t, os.IsNotExist(err), "Only the given number of rotated files should be kept")
+
+	records, err := writer.(auditReader).read(testUuid, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_2", "tid_3", "tid_4"}, auditTransactionIDs(records), "The records still kept should be read back oldest first")
+	records, err = writer.(auditReader).read(testUuid, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_3", "tid_4"}, auditTransactionIDs(records), "Only the most recent records should be read back")
+}
+
+func auditTransactionIDs(records []AuditRecord) []string {
+	var tids []string
+	for _, record := range records {
+		tids = append(tids, record.TransactionID)
+	}
+	return tids
+}
+
+// storedAuditRecords is an audit log that can be read back, holding its records oldest first
+type storedAuditRecords []AuditRecord
+
+func (r storedAuditRecords) read(uuid string, limit int) ([]AuditRecord, error) {
+	return nil, nil
+}
+
+func (r storedAuditRecords) scan(fn func(AuditRecord)) error {
+	for _, record := range r {
+		fn(record)
+	}
+	return nil
+}
+
+func TestAuditLogSeedKeepsRecordsWrittenSinceStart(t *testing.T) {
+	a := newAuditLog(NewKafkaAuditWriter(&mockConcordanceProducer{}), 3)
+	concordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+	a.record(context.Background(), testUuid, "DELETE", concordance, "tid_live", NO_CONTENT, nil, nil)
+	a.record(context.Background(), "5bd4a0ac-0b4a-4a1a-9c38-6d2e0d8f1c27", "DELETE", concordance, "tid_other", NO_CONTENT, nil, nil)
+
+	a.seed(storedAuditRecords{
+		{ConceptUuid: testUuid, TransactionID: "tid_old", After: []ConcordedId{}},
+		{ConceptUuid: "d8e1c8c8-5b3a-4b8e-9a6b-6d5b0b8c4f11", TransactionID: "tid_evicted"},
+		{ConceptUuid: "0f9b6b3e-8f0c-4d2c-9f7c-5a3b1c2d4e5f", TransactionID: "tid_kept"},
+	})
+
+	records, err := a.history(testUuid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_old", "tid_live"}, auditTransactionIDs(records), "The records read back should come before those written since the service started")
+	records, err = a.history("0f9b6b3e-8f0c-4d2c-9f7c-5a3b1c2d4e5f")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_kept"}, auditTransactionIDs(records))
+	records, err = a.history("d8e1c8c8-5b3a-4b8e-9a6b-6d5b0b8c4f11")
+	assert.NoError(t, err)
+	assert.Empty(t, records, "The concepts read back should not evict those written since the service started")
+}
+
+func TestKafkaAuditLog(t *testing.T) {
+	producer := &mockConcordanceProducer{}
+	mockClient := mockHttpClient{resp: "", statusCode: 204}
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient, WithAuditLog(NewKafkaAuditWriter(producer), 1))
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+
+	ctx := withAuditSource(context.Background(), AuditSource{Type: "replay", File: "export.json", Document: 3})
+	for i := 0; i < 2; i++ {
+		_, _, err := ts.makeRelevantRequest(ctx, testUuid, noConcordance, "tid_replay")
+		assert.NoError(t, err)
+	}
+
+	if assert.Len(t, producer.messages, 2) {
+		message := producer.messages[1]
+		assert.Equal(t, testUuid, message.key, "Audit records should be keyed by concept uuid")
+		assert.Equal(t, auditMessageType, message.message.Headers[messageTypeHeader])
+		assert.Equal(t, "tid_replay", message.message.Headers["X-Request-Id"])
+		var record AuditRecord
+		assert.NoError(t, json.Unmarshal([]byte(message.message.Body), &record))
+		assert.Equal(t, AuditSource{Type: "replay", File: "export.json", Document: 3}, record.Source)
+		assert.Equal(t, NO_CONTENT.String(), record.Status)
+		assert.Equal(t, []ConcordedId{}, record.Before, "A concept deleted by the previous request should have had no concordances before")
+	}
+
+	records, err := ts.audit.history(testUuid)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2, "The records of a Kafka audit log should be kept in memory")
+
+	_, _, err = ts.makeRelevantRequest(ctx, "d8e1c8c8-5b3a-4b8e-9a6b-6d5b0b8c4f11", noConcordance, "tid_replay")
+	assert.NoError(t, err)
+	records, err = ts.audit.history(testUuid)
+	assert.NoError(t, err)
+	assert.Empty(t, records, "The least recently written concept should be evicted once the history is full")
+}
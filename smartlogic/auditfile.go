@@ -0,0 +1,215 @@
+package smartlogic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAuditLineSize bounds the length of a line read back from the audit file
+const maxAuditLineSize = 4 * 1024 * 1024
+
+// auditFile appends audit records to a local JSONL file. Once the file would grow beyond maxBytes it is rotated: it is renamed to
+// path.1, the previous path.1 to path.2 and so on, keeping at most maxFiles rotated files.
+type auditFile struct {
+	lock     sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewAuditFile opens the audit file at path for appending, creating it and its directory if needed
+func NewAuditFile(path string, maxBytes int64, maxFiles int) (AuditWriter, error) {
+	if maxBytes <= 0 || maxFiles < 1 {
+		return nil, errors.New("the maximum size of the audit file and the number of rotated files kept must be positive")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &auditFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *auditFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *auditFile) Write(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return errors.New("audit file is closed")
+	}
+	if f.size > 0 && f.size+int64(len(line)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				if err := f.open(); err != nil {
+					return err
+				}
+			}
+			log.WithError(err).WithField("file", f.path).Error("Cannot rotate audit file; appending to it beyond its maximum size")
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and starts a new file
+func (f *auditFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if err := os.Remove(f.rotatedPath(f.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(f.rotatedPath(i), f.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.rotatedPath(1)); err != nil {
+		return err
+	}
+	log.WithField("file", f.path).Info("Rotated audit file")
+	return f.open()
+}
+
+func (f *auditFile) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+func (f *auditFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// read returns the most recent records of a concept, up to limit, oldest first. The files are read from the current one back, and
+// the older ones are not read once limit records have been found.
+func (f *auditFile) read(uuid string, limit int) ([]AuditRecord, error) {
+	files, err := f.openAll()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(files)
+
+	records := []AuditRecord{}
+	for i := len(files) - 1; i >= 0 && len(records) < limit; i-- {
+		var fileRecords []AuditRecord
+		err := scanAuditFile(files[i], []byte(uuid), func(record AuditRecord) {
+			if record.ConceptUuid == uuid {
+				fileRecords = append(fileRecords, record)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		records = append(fileRecords, records...)
+	}
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records, nil
+}
+
+// scan passes the records of the rotated files, oldest first, and then of the current file to fn
+func (f *auditFile) scan(fn func(AuditRecord)) error {
+	files, err := f.openAll()
+	if err != nil {
+		return err
+	}
+	defer closeAll(files)
+
+	for _, file := range files {
+		if err := scanAuditFile(file, nil, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openAll opens the rotated files, oldest first, and the current file. They are opened while holding the lock, so that a rotation
+// cannot move them in between, but can be read without it, so that writes are not held up.
+func (f *auditFile) openAll() ([]*os.File, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var files []*os.File
+	for i := f.maxFiles; i >= 0; i-- {
+		path := f.path
+		if i > 0 {
+			path = f.rotatedPath(i)
+		}
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			closeAll(files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// scanAuditFile passes the records of the lines of file that contain filter to fn
+func scanAuditFile(file *os.File, filter []byte, fn func(AuditRecord)) error {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxAuditLineSize)
+	for scanner.Scan() {
+		if !bytes.Contains(scanner.Bytes(), filter) {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.WithError(err).WithField("file", file.Name()).Warn("Skipping unreadable line of audit file")
+			continue
+		}
+		fn(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read audit file %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+func closeAll(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
@@ -0,0 +1,198 @@
+package smartlogic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// RoleRead allows the endpoints that read the history of concordances without changing them
+	RoleRead = "read"
+	// RoleWrite allows the endpoints that write or delete concordances
+	RoleWrite = "write"
+)
+
+var knownRoles = map[string]bool{RoleRead: true, RoleWrite: true}
+
+// Principal is a client of the API, identified by an API key, a bearer token or both, and granted roles
+type Principal struct {
+	Name   string   `json:"name"`
+	APIKey string   `json:"apiKey,omitempty"`
+	Token  string   `json:"token,omitempty"`
+	Roles  []string `json:"roles"`
+}
+
+func (p *Principal) hasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the principal of a request by its API key header or its Authorization: Bearer token.
+// Secrets are looked up by their SHA-256 digest, so that the time taken does not depend on how much of a secret matched.
+type Authenticator struct {
+	apiKeyHeader string
+	apiKeys      map[[sha256.Size]byte]*Principal
+	tokens       map[[sha256.Size]byte]*Principal
+}
+
+// NewAuthenticator checks the principals, which need a name, a secret and known roles, and returns an Authenticator for them
+func NewAuthenticator(principals []Principal, apiKeyHeader string) (*Authenticator, error) {
+	if apiKeyHeader == "" {
+		apiKeyHeader = "X-Api-Key"
+	}
+	a := &Authenticator{apiKeyHeader: apiKeyHeader, apiKeys: map[[sha256.Size]byte]*Principal{}, tokens: map[[sha256.Size]byte]*Principal{}}
+	names := map[string]bool{}
+	for i := range principals {
+		p := &principals[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("principal %d has no name", i)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate principal %q", p.Name)
+		}
+		names[p.Name] = true
+		if p.APIKey == "" && p.Token == "" {
+			return nil, fmt.Errorf("principal %q has neither an API key nor a token", p.Name)
+		}
+		for _, role := range p.Roles {
+			if !knownRoles[role] {
+				return nil, fmt.Errorf("principal %q has unknown role %q; expected %s or %s", p.Name, role, RoleRead, RoleWrite)
+			}
+		}
+		if p.APIKey != "" {
+			if err := addSecret(a.apiKeys, p.APIKey, p); err != nil {
+				return nil, err
+			}
+		}
+		if p.Token != "" {
+			if err := addSecret(a.tokens, p.Token, p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return a, nil
+}
+
+// LoadAuthenticator reads the principals from a JSON file holding a list of them
+func LoadAuthenticator(file string, apiKeyHeader string) (*Authenticator, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var principals []Principal
+	if err := json.Unmarshal(content, &principals); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %v", file, err)
+	}
+	return NewAuthenticator(principals, apiKeyHeader)
+}
+
+func addSecret(secrets map[[sha256.Size]byte]*Principal, secret string, p *Principal) error {
+	digest := sha256.Sum256([]byte(secret))
+	if other, found := secrets[digest]; found {
+		return fmt.Errorf("principals %q and %q share a secret", other.Name, p.Name)
+	}
+	secrets[digest] = p
+	return nil
+}
+
+type principalKey struct{}
+
+// principalFrom returns the name of the principal a request was authenticated as, if any
+func principalFrom(ctx context.Context) string {
+	name, _ := ctx.Value(principalKey{}).(string)
+	return name
+}
+
+var (
+	errNoCredentials      = errors.New("no API key or bearer token")
+	errInvalidCredentials = errors.New("unknown API key or bearer token")
+)
+
+// authenticate returns the principal of the request, or why it could not be identified
+func (a *Authenticator) authenticate(req *http.Request) (*Principal, error) {
+	if apiKey := req.Header.Get(a.apiKeyHeader); apiKey != "" {
+		if p, found := a.apiKeys[sha256.Sum256([]byte(apiKey))]; found {
+			return p, nil
+		}
+		return nil, errInvalidCredentials
+	}
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, errNoCredentials
+	}
+	const bearer = "bearer "
+	if len(authorization) <= len(bearer) || !strings.EqualFold(authorization[:len(bearer)], bearer) {
+		return nil, errors.New("Authorization header is not a bearer token")
+	}
+	if p, found := a.tokens[sha256.Sum256([]byte(strings.TrimSpace(authorization[len(bearer):])))]; found {
+		return p, nil
+	}
+	return nil, errInvalidCredentials
+}
+
+// WithAuthenticator makes the endpoints that write or delete concordances require a principal with the write role.
+// Without an Authenticator every endpoint is open.
+func WithAuthenticator(auth *Authenticator) HandlerOption {
+	return func(h *SmartlogicConcordanceTransformerHandler) {
+		h.auth = auth
+	}
+}
+
+// requireRole only lets requests of principals with role through to next. Rejected requests are answered with 401 when the principal
+// could not be identified and 403 when it lacks the role, and logged with their transaction id, which next is given as well, along with the principal.
+func (h *SmartlogicConcordanceTransformerHandler) requireRole(role string, next http.Handler) http.Handler {
+	if h.auth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		tid := transactionidutils.GetTransactionIDFromRequest(req)
+		req.Header.Set(transactionidutils.TransactionIDHeader, tid)
+		fields := log.Fields{"transaction_id": tid, "method": req.Method, "path": req.URL.Path, "remote_addr": req.RemoteAddr, "required_role": role}
+
+		principal, err := h.auth.authenticate(req)
+		if err != nil {
+			log.WithError(err).WithFields(fields).Warn("Rejected unauthenticated request")
+			observeAuthRejection(routePath(req), http.StatusUnauthorized)
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("X-Request-Id", tid)
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="smartlogic-concordance-transformer"`)
+			writeJSONError(rw, "Authentication required: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		fields["principal"] = principal.Name
+		if !principal.hasRole(role) {
+			log.WithFields(fields).Warn("Rejected request of principal without the required role")
+			observeAuthRejection(routePath(req), http.StatusForbidden)
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("X-Request-Id", tid)
+			writeJSONError(rw, "Forbidden: the "+role+" role is required", http.StatusForbidden)
+			return
+		}
+		log.WithFields(fields).Debug("Authorised request")
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), principalKey{}, principal.Name)))
+	})
+}
+
+// routePath is the path template of the route a request matched, so that metrics are not labelled with the uuid of every concept queried
+func routePath(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return req.URL.Path
+}
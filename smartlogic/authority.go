@@ -0,0 +1,297 @@
+package smartlogic
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/Financial-Times/uuid-utils-go"
+	"github.com/pborman/uuid"
+)
+
+// ConceptFamily distinguishes the smartlogic models a concept can come from, as each uses its own JSON-LD predicates
+type ConceptFamily int
+
+const (
+	EditorialConcept ConceptFamily = iota
+	ManagedLocationConcept
+)
+
+// DuplicatePolicy decides what happens when an identifier generates a uuid that is already concorded
+type DuplicatePolicy int
+
+const (
+	DuplicateError DuplicatePolicy = iota
+	DuplicateSkip
+)
+
+// Authority describes a concordance identifier scheme: where its identifiers are found in the JSON-LD, how they are validated and how
+// they are turned into UPP uuids. Validate may be nil when every value is acceptable; SkipBlank ignores empty values instead of validating them.
+type Authority struct {
+	Name        string
+	Predicates  map[ConceptFamily][]string
+	Validate    func(value string) error
+	DeriveUUID  func(value string) string
+	OnDuplicate DuplicatePolicy
+	SkipBlank   bool
+}
+
+func (a Authority) convert(value string) (string, error) {
+	if a.Validate != nil {
+		if err := a.Validate(value); err != nil {
+			return "", err
+		}
+	}
+	return a.DeriveUUID(value), nil
+}
+
+type authorityRegistry struct {
+	sync.RWMutex
+	authorities []Authority
+}
+
+func (r *authorityRegistry) register(a Authority) {
+	r.Lock()
+	defer r.Unlock()
+	for i, existing := range r.authorities {
+		if existing.Name == a.Name {
+			r.authorities[i] = a
+			return
+		}
+	}
+	r.authorities = append(r.authorities, a)
+}
+
+// all returns the registered authorities in registration order, which is the order their concordances are emitted in
+func (r *authorityRegistry) all() []Authority {
+	r.RLock()
+	defer r.RUnlock()
+	authorities := make([]Authority, len(r.authorities))
+	copy(authorities, r.authorities)
+	return authorities
+}
+
+func (r *authorityRegistry) get(name string) (Authority, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	for _, a := range r.authorities {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Authority{}, false
+}
+
+var (
+	tmeAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_TME,
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept:       {"http://www.ft.com/ontology/TMEIdentifier"},
+			ManagedLocationConcept: {"http://www.ft.com/ontology/managedlocation/TMEIdentifier"},
+		},
+		Validate:    validateTmeId,
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateError,
+	}
+	factsetAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_FACTSET,
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept:       {"http://www.ft.com/ontology/factsetIdentifier"},
+			ManagedLocationConcept: {"http://www.ft.com/ontology/managedlocation/factsetIdentifier"},
+		},
+		Validate:    validateFactsetId,
+		DeriveUUID:  uuidutils.DeriveFactsetUUID,
+		OnDuplicate: DuplicateError,
+	}
+	leiAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_LEI,
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept: {"http://www.ft.com/ontology/leiIdentifier"},
+		},
+		Validate:    validateLeiId,
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateError,
+	}
+	figiAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_FIGI,
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept: {"http://www.ft.com/ontology/figiIdentifier"},
+		},
+		Validate:    validateFigiId,
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateError,
+	}
+	isinAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_ISIN,
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept: {"http://www.ft.com/ontology/isinIdentifier"},
+		},
+		Validate:    validateIsinId,
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateError,
+	}
+	dbpediaAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_DBPEDIA,
+		Predicates: map[ConceptFamily][]string{
+			ManagedLocationConcept: {"http://www.ft.com/ontology/managedlocation/dbpediaId"},
+		},
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateSkip,
+		SkipBlank:   true,
+	}
+	geonamesAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_GEONAMES,
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept:       {"http://www.ft.com/ontology/geonamesIdentifier"},
+			ManagedLocationConcept: {"http://www.ft.com/ontology/managedlocation/geonamesId"},
+		},
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateSkip,
+		SkipBlank:   true,
+	}
+	wikidataAuthority = Authority{
+		Name: CONCORDANCE_AUTHORITY_WIKIDATA,
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept:       {"http://www.ft.com/ontology/wikidataIdentifier"},
+			ManagedLocationConcept: {"http://www.ft.com/ontology/managedlocation/wikidataId"},
+		},
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateSkip,
+		SkipBlank:   true,
+	}
+
+	authorities = &authorityRegistry{}
+)
+
+func init() {
+	RegisterAuthority(tmeAuthority)
+	RegisterAuthority(factsetAuthority)
+	RegisterAuthority(leiAuthority)
+	RegisterAuthority(figiAuthority)
+	RegisterAuthority(isinAuthority)
+	RegisterAuthority(dbpediaAuthority)
+	RegisterAuthority(geonamesAuthority)
+	RegisterAuthority(wikidataAuthority)
+}
+
+// RegisterAuthority adds a concordance authority, or replaces the one with the same name. It must be called before any payload is decoded.
+func RegisterAuthority(a Authority) {
+	authorities.register(a)
+}
+
+func validateTmeId(tmeId string) error {
+	subStrings := strings.Split(tmeId, "-")
+	if len(subStrings) != 2 || !validateSubstrings(subStrings) {
+		return errors.New("Bad Request: Concordance id " + tmeId + " is not a valid TME Id")
+	}
+	return nil
+}
+
+func validateFactsetId(factsetId string) error {
+	if len(factsetId) != 8 || factsetId[0] != '0' || factsetId[6:8] != "-E" {
+		return errors.New("Bad Request: Concordance id " + factsetId + " is not a valid FACTSET Id")
+	}
+	return nil
+}
+
+// validateLeiId checks an ISO 17442 Legal Entity Identifier: 18 upper case alphanumeric characters followed by two check digits,
+// which are valid when the whole code, with letters replaced by the numbers 10 to 35, is 1 modulo 97
+func validateLeiId(leiId string) error {
+	invalid := errors.New("Bad Request: Concordance id " + leiId + " is not a valid LEI Id")
+	if len(leiId) != 20 {
+		return invalid
+	}
+	remainder := 0
+	for i, c := range leiId {
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z' && i < 18:
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return invalid
+		}
+	}
+	if remainder != 1 {
+		return invalid
+	}
+	return nil
+}
+
+// validateFigiId checks a Bloomberg issued OpenFIGI: BBG followed by eight upper case consonants or digits and a check digit,
+// calculated like a Luhn digit over the character values with letters counting as 10 to 35
+func validateFigiId(figiId string) error {
+	invalid := errors.New("Bad Request: Concordance id " + figiId + " is not a valid FIGI Id")
+	if len(figiId) != 12 || !strings.HasPrefix(figiId, "BBG") {
+		return invalid
+	}
+	sum := 0
+	for i, c := range figiId[:11] {
+		var value int
+		switch {
+		case c >= '0' && c <= '9':
+			value = int(c - '0')
+		case c >= 'A' && c <= 'Z' && !strings.ContainsRune("AEIOU", c):
+			value = int(c-'A') + 10
+		default:
+			return invalid
+		}
+		if i%2 == 1 {
+			value *= 2
+		}
+		sum += value/10 + value%10
+	}
+	if int(figiId[11]-'0') != (10-sum%10)%10 {
+		return invalid
+	}
+	return nil
+}
+
+// validateIsinId checks an ISO 6166 International Securities Identification Number: a two letter country code, nine alphanumeric
+// characters and a Luhn check digit over the code with letters expanded to the numbers 10 to 35
+func validateIsinId(isinId string) error {
+	invalid := errors.New("Bad Request: Concordance id " + isinId + " is not a valid ISIN Id")
+	if len(isinId) != 12 {
+		return invalid
+	}
+	digits := make([]int, 0, 24)
+	for i, c := range isinId {
+		switch {
+		case c >= '0' && c <= '9' && i >= 2:
+			digits = append(digits, int(c-'0'))
+		case c >= 'A' && c <= 'Z' && i < 11:
+			value := int(c-'A') + 10
+			digits = append(digits, value/10, value%10)
+		default:
+			return invalid
+		}
+	}
+	sum := 0
+	for i := range digits {
+		digit := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	if sum%10 != 0 {
+		return invalid
+	}
+	return nil
+}
+
+func validateSubstrings(subStrings []string) bool {
+	for _, string := range subStrings {
+		if string == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func convertToUuid(id string) string {
+	return uuid.NewMD5(uuid.UUID{}, []byte(id)).String()
+}
@@ -0,0 +1,148 @@
+package smartlogic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// batchResultResponse is one line of the NDJSON response of the batch endpoint. Every concept of a document gets its own line;
+// a document that cannot be decoded or has no @graph gets a single line without a uuid.
+type batchResultResponse struct {
+	Document int `json:"document"`
+	conceptResultResponse
+}
+
+// batchDecoder reads the Smartlogic documents of a batch one at a time, from either a JSON array or newline delimited JSON
+type batchDecoder struct {
+	reader  *bufio.Reader
+	decoder *json.Decoder
+}
+
+func newBatchDecoder(r io.Reader) (*batchDecoder, error) {
+	reader := bufio.NewReader(r)
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return &batchDecoder{reader: reader}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			reader.ReadByte()
+			continue
+		case '[':
+			decoder := json.NewDecoder(reader)
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return &batchDecoder{decoder: decoder}, nil
+		default:
+			return &batchDecoder{reader: reader}, nil
+		}
+	}
+}
+
+// next decodes the following document of the batch, returning io.EOF once there are none left. Malformed JSON inside an array
+// cannot be recovered from and is reported as fatal; errors in a single NDJSON line only affect that document.
+func (d *batchDecoder) next(concept *SmartlogicConcept) (fatal bool, err error) {
+	if d.decoder != nil {
+		if !d.decoder.More() {
+			return false, io.EOF
+		}
+		err := d.decoder.Decode(concept)
+		var syntaxErr *json.SyntaxError
+		return errors.As(err, &syntaxErr) || err == io.ErrUnexpectedEOF, err
+	}
+
+	for {
+		line, err := d.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return true, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err == io.EOF {
+				return false, io.EOF
+			}
+			continue
+		}
+		return false, json.Unmarshal(line, concept)
+	}
+}
+
+// BatchHandler transforms a stream of Smartlogic documents, sent as a JSON array or as NDJSON, and streams back an NDJSON line per concept.
+// Documents are decoded and answered one at a time so that the batch is never held in memory.
+func (h *SmartlogicConcordanceTransformerHandler) BatchHandler(rw http.ResponseWriter, req *http.Request) {
+	tid := transactionidutils.GetTransactionIDFromRequest(req)
+	rw.Header().Set("X-Request-Id", tid)
+	defer req.Body.Close()
+	ctx := requestTraceContext(req)
+
+	decoder, err := newBatchDecoder(req.Body)
+	if err != nil {
+		log.WithError(err).WithField("transaction_id", tid).Error("Error whilst processing request body")
+		rw.Header().Set("Content-Type", "application/json")
+		writeJSONError(rw, "Error whilst processing request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	flusher, _ := rw.(http.Flusher)
+
+	documents, failures := 0, 0
+	for fatal := false; !fatal; documents++ {
+		var smartLogicConcept = SmartlogicConcept{}
+		var err error
+		fatal, err = decoder.next(&smartLogicConcept)
+		if err == io.EOF {
+			break
+		}
+
+		var lines []batchResultResponse
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "document": documents}).Error("Error whilst processing batch document")
+			lines = []batchResultResponse{{Document: documents, conceptResultResponse: conceptResultResponse{Status: http.StatusBadRequest, Message: "Error whilst processing request body: " + err.Error()}}}
+		} else {
+			lines = batchResults(ctx, documents, smartLogicConcept, tid)
+		}
+
+		for _, line := range lines {
+			if line.Status != http.StatusOK {
+				failures++
+			}
+			if err := encoder.Encode(line); err != nil {
+				log.WithError(err).WithField("transaction_id", tid).Error("Error whilst writing batch response")
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	log.WithFields(log.Fields{"transaction_id": tid, "documents": documents, "failures": failures}).Info("Smartlogic batch transformed")
+}
+
+func batchResults(ctx context.Context, document int, smartLogicConcept SmartlogicConcept, tid string) []batchResultResponse {
+	updateStatus, results, err := convertToUppConcordances(ctx, smartLogicConcept, tid, failFast)
+	if err != nil {
+		return []batchResultResponse{{Document: document, conceptResultResponse: newErrorResponse(updateStatus, err)}}
+	}
+
+	lines := make([]batchResultResponse, 0, len(results))
+	for _, result := range results {
+		lines = append(lines, batchResultResponse{Document: document, conceptResultResponse: newConceptResultResponse(result, true)})
+	}
+	return lines
+}
@@ -0,0 +1,75 @@
+package smartlogic
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchHandler(t *testing.T) {
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockHttpClient{}), mockConsumer{}, nil)
+	r := mux.NewRouter()
+	h.RegisterHandlers(r)
+
+	tmeConcept := `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`
+	invalidTmeConcept := `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "XXXXX"}]}]}`
+	noGraph := `{"@graph": []}`
+
+	tmeResult := `{"document":%d,"uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","status":200,"concordance":{"authority":"Smartlogic","uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","concordances":[{"authority":"TME","authorityValue":"AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789","uuid":"e9f4525a-401f-3b23-a68e-e48f314cdce6"}]}}`
+	invalidTmeResult := `{"document":%d,"uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","status":400,"message":"Bad Request: Concordance id XXXXX is not a valid TME Id","code":"INVALID_IDENTIFIER","authority":"TME","value":"XXXXX","predicate":"http://www.ft.com/ontology/TMEIdentifier"}`
+	noGraphResult := `{"document":%d,"status":422,"message":"Invalid Request Json: Missing/invalid @graph field","code":"MISSING_GRAPH"}`
+
+	type testStruct struct {
+		scenarioName  string
+		body          string
+		expectedLines []string
+	}
+
+	scenarios := []testStruct{
+		{
+			scenarioName:  "ndjson",
+			body:          tmeConcept + "\n\n" + invalidTmeConcept + "\n" + noGraph + "\n",
+			expectedLines: []string{line(tmeResult, 0), line(invalidTmeResult, 1), line(noGraphResult, 2)},
+		},
+		{
+			scenarioName:  "ndjsonMalformedLineOnlyFailsThatDocument",
+			body:          "{\"@graph\": [\n" + tmeConcept,
+			expectedLines: []string{`{"document":0,"status":400,"message":"Error whilst processing request body: unexpected end of JSON input"}`, line(tmeResult, 1)},
+		},
+		{
+			scenarioName:  "jsonArray",
+			body:          " [" + tmeConcept + ",\n" + invalidTmeConcept + "," + noGraph + "]",
+			expectedLines: []string{line(tmeResult, 0), line(invalidTmeResult, 1), line(noGraphResult, 2)},
+		},
+		{
+			scenarioName:  "jsonArrayMalformedDocumentEndsBatch",
+			body:          "[" + tmeConcept + ", {\"@graph\" [], " + tmeConcept + "]",
+			expectedLines: []string{line(tmeResult, 0), `{"document":1,"status":400,"message":"Error whilst processing request body: invalid character '[' after object key"}`},
+		},
+		{
+			scenarioName:  "emptyBatch",
+			body:          "\n",
+			expectedLines: []string{},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, newRequest("POST", "/transform/batch", scenario.body))
+		assert.Equal(t, 200, rec.Code, scenario.scenarioName)
+		assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"), scenario.scenarioName)
+		lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+		if rec.Body.Len() == 0 {
+			lines = []string{}
+		}
+		assert.Equal(t, scenario.expectedLines, lines, "Failed scenario: "+scenario.scenarioName)
+	}
+}
+
+func line(format string, document int) string {
+	return strings.Replace(format, "%d", strconv.Itoa(document), 1)
+}
@@ -0,0 +1,179 @@
+package smartlogic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errCircuitOpen = errors.New("Service Unavailable: Circuit breaker to concordances-rw-neo4j is open")
+
+// CircuitBreakerConfig controls when calls to concordances-rw-neo4j are stopped.
+// The breaker opens once at least MinRequests of the last WindowSize calls have been made and FailureRate of them failed.
+// After OpenDuration the writer's __gtg is probed, closing the breaker again if it responds.
+type CircuitBreakerConfig struct {
+	FailureRate  float64
+	MinRequests  int
+	WindowSize   int
+	OpenDuration time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type circuitBreaker struct {
+	sync.Mutex
+	config   CircuitBreakerConfig
+	state    breakerState
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+	sleep    func(context.Context, time.Duration) error
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	if config.WindowSize < 1 {
+		config.WindowSize = 1
+	}
+	return &circuitBreaker{
+		config:   config,
+		outcomes: make([]bool, config.WindowSize),
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+}
+
+// allow returns errCircuitOpen while the breaker is open. Once OpenDuration has elapsed the first caller moves
+// the breaker to half-open and runs the probe, which decides whether the breaker closes or stays open.
+func (cb *circuitBreaker) allow(probe func() error) error {
+	if cb == nil {
+		return nil
+	}
+	cb.Lock()
+	switch {
+	case cb.state == breakerClosed:
+		cb.Unlock()
+		return nil
+	case cb.state == breakerHalfOpen || cb.now().Sub(cb.openedAt) < cb.config.OpenDuration:
+		cb.Unlock()
+		return errCircuitOpen
+	}
+	cb.state = breakerHalfOpen
+	cb.Unlock()
+
+	err := probe()
+
+	cb.Lock()
+	defer cb.Unlock()
+	if err != nil {
+		log.WithError(err).Warn("Concordances-rw-neo4j probe failed; circuit breaker stays open")
+		cb.open()
+		return errCircuitOpen
+	}
+	log.Info("Concordances-rw-neo4j probe succeeded; closing circuit breaker")
+	cb.state = breakerClosed
+	cb.reset()
+	return nil
+}
+
+// record adds the outcome of a call to the writer to the window, opening the breaker when the failure rate is reached.
+func (cb *circuitBreaker) record(success bool) {
+	if cb == nil {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.state != breakerClosed {
+		return
+	}
+
+	if cb.count == len(cb.outcomes) {
+		if !cb.outcomes[cb.next] {
+			cb.failures--
+		}
+	} else {
+		cb.count++
+	}
+	cb.outcomes[cb.next] = success
+	cb.next = (cb.next + 1) % len(cb.outcomes)
+	if !success {
+		cb.failures++
+	}
+
+	if cb.count >= cb.config.MinRequests && float64(cb.failures)/float64(cb.count) >= cb.config.FailureRate {
+		log.WithFields(log.Fields{"failures": cb.failures, "requests": cb.count}).Error("Failure rate of requests to concordances-rw-neo4j reached; opening circuit breaker")
+		cb.open()
+	}
+}
+
+// waitUntilClosed blocks until the breaker lets calls through, probing the writer every OpenDuration. It gives up when ctx is done.
+func (cb *circuitBreaker) waitUntilClosed(ctx context.Context, probe func() error, tid string) {
+	if cb == nil {
+		return
+	}
+	paused := false
+	for cb.allow(probe) != nil {
+		if !paused {
+			log.WithField("transaction_id", tid).Warn("Circuit breaker to concordances-rw-neo4j is open; pausing Kafka consumption")
+			paused = true
+		}
+		if err := cb.sleep(ctx, cb.untilProbe()); err != nil {
+			log.WithError(err).WithField("transaction_id", tid).Warn("Stopped waiting for circuit breaker to concordances-rw-neo4j to close")
+			return
+		}
+	}
+	if paused {
+		log.WithField("transaction_id", tid).Info("Circuit breaker to concordances-rw-neo4j is closed; resuming Kafka consumption")
+	}
+}
+
+func (cb *circuitBreaker) currentState() breakerState {
+	if cb == nil {
+		return breakerClosed
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	return cb.state
+}
+
+func (cb *circuitBreaker) untilProbe() time.Duration {
+	cb.Lock()
+	defer cb.Unlock()
+	wait := cb.config.OpenDuration - cb.now().Sub(cb.openedAt)
+	if wait < 10*time.Millisecond {
+		wait = 10 * time.Millisecond
+	}
+	return wait
+}
+
+func (cb *circuitBreaker) open() {
+	cb.state = breakerOpen
+	cb.openedAt = cb.now()
+	cb.reset()
+}
+
+func (cb *circuitBreaker) reset() {
+	cb.next, cb.count, cb.failures = 0, 0, 0
+}
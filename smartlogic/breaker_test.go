@@ -0,0 +1,106 @@
+package smartlogic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerOpensAtFailureRate(t *testing.T) {
+	cb := newCircuitBreaker(CircuitBreakerConfig{FailureRate: 0.5, MinRequests: 4, WindowSize: 4, OpenDuration: time.Minute})
+	okProbe := func() error { return nil }
+
+	cb.record(false)
+	cb.record(false)
+	cb.record(true)
+	assert.Equal(t, breakerClosed, cb.currentState(), "breaker should stay closed below the minimum number of requests")
+	assert.NoError(t, cb.allow(okProbe))
+
+	cb.record(true)
+	assert.Equal(t, breakerOpen, cb.currentState(), "breaker should open when half of the window failed")
+	assert.Equal(t, errCircuitOpen, cb.allow(okProbe))
+}
+
+func TestCircuitBreakerWindowForgetsOldFailures(t *testing.T) {
+	cb := newCircuitBreaker(CircuitBreakerConfig{FailureRate: 0.5, MinRequests: 3, WindowSize: 3, OpenDuration: time.Minute})
+
+	cb.record(false)
+	cb.record(true)
+	cb.record(true)
+	cb.record(true)
+	cb.record(false)
+	assert.Equal(t, breakerClosed, cb.currentState(), "first failure should have left the window")
+}
+
+func TestCircuitBreakerProbesAfterOpenDuration(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(CircuitBreakerConfig{FailureRate: 1, MinRequests: 1, WindowSize: 1, OpenDuration: time.Minute})
+	cb.now = func() time.Time { return now }
+	probes := 0
+	failingProbe := func() error { probes++; return errors.New("writer down") }
+	okProbe := func() error { probes++; return nil }
+
+	cb.record(false)
+	assert.Equal(t, errCircuitOpen, cb.allow(okProbe))
+	assert.Equal(t, 0, probes, "writer should not be probed before the open duration elapsed")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, errCircuitOpen, cb.allow(failingProbe))
+	assert.Equal(t, 1, probes)
+	assert.Equal(t, breakerOpen, cb.currentState(), "failed probe should reopen the breaker")
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, cb.allow(okProbe))
+	assert.Equal(t, 2, probes)
+	assert.Equal(t, breakerClosed, cb.currentState(), "successful probe should close the breaker")
+}
+
+func TestCircuitBreakerWaitUntilClosed(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(CircuitBreakerConfig{FailureRate: 1, MinRequests: 1, WindowSize: 1, OpenDuration: time.Minute})
+	cb.now = func() time.Time { return now }
+	var waits []time.Duration
+	cb.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		now = now.Add(d)
+		return nil
+	}
+	probes := 0
+	probe := func() error {
+		probes++
+		if probes < 2 {
+			return errors.New("writer down")
+		}
+		return nil
+	}
+
+	cb.record(false)
+	cb.waitUntilClosed(context.Background(), probe, "tid_test")
+	assert.Equal(t, []time.Duration{time.Minute, time.Minute}, waits)
+	assert.Equal(t, breakerClosed, cb.currentState())
+}
+
+func TestNilCircuitBreakerAllowsEverything(t *testing.T) {
+	var cb *circuitBreaker
+	cb.record(false)
+	cb.waitUntilClosed(context.Background(), nil, "tid_test")
+	assert.NoError(t, cb.allow(nil))
+	assert.Equal(t, breakerClosed, cb.currentState())
+}
+
+func TestMakeRelevantRequestRejectedWhileCircuitOpen(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{concordedTmeId}}
+	client := &recordingHttpClient{statusCodes: []int{503, 200}, errs: []error{nil, nil}}
+	ts := NewTransformerService("", writerUrl, client, WithCircuitBreaker(CircuitBreakerConfig{FailureRate: 1, MinRequests: 1, WindowSize: 1, OpenDuration: time.Minute}))
+
+	reqStatus, _, _ := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_test")
+	assert.Equal(t, INTERNAL_ERROR, reqStatus)
+
+	reqStatus, _, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_test")
+	assert.Equal(t, SERVICE_UNAVAILABLE, reqStatus)
+	assert.Equal(t, errCircuitOpen, err)
+	assert.Equal(t, 1, client.calls(), "writer should not be called while the circuit is open")
+}
@@ -0,0 +1,53 @@
+package smartlogic
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	failureStatusHeader    = "Failure-Status"
+	failureReasonHeader    = "Failure-Reason"
+	messageTimestampHeader = "Message-Timestamp"
+	messageTimestampFormat = "2006-01-02T15:04:05.000Z0700"
+)
+
+// publishDeadLetter forwards a message that could not be transformed or written to the dead-letter topic.
+// The original body is kept untouched so that the message can be replayed onto the SmartlogicConcept topic once the problem has been fixed.
+func (h *SmartlogicConcordanceTransformerHandler) publishDeadLetter(msg kafka.FTMessage, tid string, failureStatus status, failure error) {
+	if h.deadLetterProducer == nil {
+		return
+	}
+
+	headers := make(map[string]string, len(msg.Headers)+4)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["X-Request-Id"] = tid
+	headers[failureStatusHeader] = failureStatus.String()
+	headers[failureReasonHeader] = singleLine(failure.Error())
+	headers[messageTimestampHeader] = time.Now().UTC().Format(messageTimestampFormat)
+
+	err := h.deadLetterProducer.SendMessage(kafka.NewFTMessage(headers, msg.Body))
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "failure_status": failureStatus.String()}).Error("Failed to publish message to dead-letter topic")
+		return
+	}
+	log.WithFields(log.Fields{"transaction_id": tid, "failure_status": failureStatus.String()}).Warn("Message published to dead-letter topic")
+}
+
+func (h *SmartlogicConcordanceTransformerHandler) checkDeadLetterConnectivity() (string, error) {
+	if err := h.deadLetterProducer.ConnectivityCheck(); err != nil {
+		log.WithError(err).Error("Error verifying open connection to the dead-letter topic")
+		return "Error connecting to the dead-letter topic", err
+	}
+	return "Successfully connected to the dead-letter topic", nil
+}
+
+// singleLine keeps multi-line error text from breaking the header block of the Kafka message.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,160 @@
+package smartlogic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// debouncer holds back the write of a concept's concordance for a window after the first update of a burst, so that a burst of updates
+// for the concept results in a single request to the writer with the latest concordance. Writes for a concept are sent in the order
+// they were scheduled; once flushed, writes are sent without waiting.
+type debouncer struct {
+	sync.Mutex
+	window   time.Duration
+	pending  map[string]*debouncedWrite
+	sending  map[string]chan struct{}
+	flushing bool
+}
+
+// debouncedWrite sends the latest update of a concept once its window has elapsed; coalesce is called for every update it replaces.
+type debouncedWrite struct {
+	send     func()
+	coalesce func()
+	after    <-chan struct{}
+	sent     chan struct{}
+}
+
+func newDebouncer(window time.Duration) *debouncer {
+	return &debouncer{window: window, pending: map[string]*debouncedWrite{}, sending: map[string]chan struct{}{}}
+}
+
+// schedule sends the update for uuid when the window of the write pending for it elapses, starting a new window when there is none.
+// The update already pending, if any, is coalesced instead of being sent.
+func (d *debouncer) schedule(uuid string, send func(), coalesce func()) {
+	d.Lock()
+	if write, ok := d.pending[uuid]; ok {
+		superseded := write.coalesce
+		write.send, write.coalesce = send, coalesce
+		d.Unlock()
+		writesCoalesced.Inc()
+		superseded()
+		return
+	}
+	write := &debouncedWrite{send: send, coalesce: coalesce, after: d.sending[uuid], sent: make(chan struct{})}
+	d.pending[uuid] = write
+	window := d.window
+	if d.flushing {
+		window = 0
+	}
+	d.Unlock()
+	time.AfterFunc(window, func() { d.fire(uuid, write) })
+}
+
+// supersede coalesces the update pending for uuid, if any, without sending it
+func (d *debouncer) supersede(uuid string) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	write, ok := d.pending[uuid]
+	if ok {
+		delete(d.pending, uuid)
+	}
+	d.Unlock()
+	if ok {
+		writesCoalesced.Inc()
+		write.coalesce()
+	}
+}
+
+func (d *debouncer) fire(uuid string, write *debouncedWrite) {
+	d.Lock()
+	if d.pending[uuid] != write {
+		d.Unlock()
+		return
+	}
+	delete(d.pending, uuid)
+	d.sending[uuid] = write.sent
+	send := write.send
+	d.Unlock()
+
+	if write.after != nil {
+		<-write.after
+	}
+	send()
+	close(write.sent)
+
+	d.Lock()
+	if d.sending[uuid] == write.sent {
+		delete(d.sending, uuid)
+	}
+	d.Unlock()
+}
+
+// flush sends every pending write at once and stops holding back those scheduled afterwards, so that shutdown does not wait for windows to elapse
+func (d *debouncer) flush() {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	d.flushing = true
+	writes := make(map[string]*debouncedWrite, len(d.pending))
+	for uuid, write := range d.pending {
+		writes[uuid] = write
+	}
+	d.Unlock()
+	if len(writes) > 0 {
+		log.WithField("pending", len(writes)).Info("[Shutdown] Sending debounced concordances without waiting for their window")
+	}
+	for uuid, write := range writes {
+		go d.fire(uuid, write)
+	}
+}
+
+// forward schedules the write of every successfully transformed concordance, recording the outcome of each against its result.
+// done is called with the results once every concordance has been written, has failed or has been coalesced into a later update.
+func (d *debouncer) forward(ctx context.Context, ts *TransformerService, results []ConceptResult, tid string, done func([]ConceptResult)) {
+	var lock sync.Mutex
+	remaining := 0
+	for _, result := range results {
+		if result.Err == nil {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		done(results)
+		return
+	}
+
+	settle := func(i int, reqStatus status, outcomes []WriterOutcome, err error) {
+		lock.Lock()
+		results[i].Status = reqStatus
+		results[i].Err = err
+		results[i].Writers = outcomes
+		remaining--
+		last := remaining == 0
+		lock.Unlock()
+		if last {
+			done(results)
+		}
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		i, uuid, uppConcordance := i, result.ConceptUuid, result.UppConcordance
+		d.schedule(uuid, func() {
+			reqStatus, outcomes, err := ts.makeRelevantRequest(ctx, uuid, uppConcordance, tid)
+			if err == nil {
+				log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Info("Forwarded concordance record to rw")
+			}
+			settle(i, reqStatus, outcomes, err)
+		}, func() {
+			log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Info("Concordance record superseded by a later update; not forwarded to rw")
+			settle(i, COALESCED, nil, nil)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package smartlogic
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type debounceRecorder struct {
+	sync.Mutex
+	wg        sync.WaitGroup
+	sent      []string
+	coalesced []string
+}
+
+func (r *debounceRecorder) schedule(d *debouncer, uuid string, update string) {
+	r.wg.Add(1)
+	d.schedule(uuid, func() {
+		defer r.wg.Done()
+		r.Lock()
+		defer r.Unlock()
+		r.sent = append(r.sent, update)
+	}, func() {
+		defer r.wg.Done()
+		r.Lock()
+		defer r.Unlock()
+		r.coalesced = append(r.coalesced, update)
+	})
+}
+
+func TestDebouncerSendsLatestUpdateOfBurst(t *testing.T) {
+	coalesced := testutil.ToFloat64(writesCoalesced)
+	d := newDebouncer(30 * time.Millisecond)
+	r := &debounceRecorder{}
+
+	r.schedule(d, "a", "a1")
+	r.schedule(d, "b", "b1")
+	r.schedule(d, "a", "a2")
+	r.schedule(d, "a", "a3")
+	r.wg.Wait()
+
+	assert.ElementsMatch(t, []string{"a3", "b1"}, r.sent)
+	assert.Equal(t, []string{"a1", "a2"}, r.coalesced)
+	assert.Equal(t, float64(2), testutil.ToFloat64(writesCoalesced)-coalesced)
+}
+
+func TestDebouncerKeepsWritesForAConceptInOrder(t *testing.T) {
+	d := newDebouncer(time.Millisecond)
+	var lock sync.Mutex
+	var sent []string
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	d.schedule("a", func() {
+		close(started)
+		<-release
+		lock.Lock()
+		defer lock.Unlock()
+		sent = append(sent, "a1")
+	}, func() {})
+	<-started
+	d.schedule("a", func() {
+		lock.Lock()
+		defer lock.Unlock()
+		sent = append(sent, "a2")
+		close(done)
+	}, func() {})
+
+	time.Sleep(20 * time.Millisecond)
+	lock.Lock()
+	assert.Empty(t, sent, "The later write should wait for the one being sent")
+	lock.Unlock()
+
+	close(release)
+	<-done
+	assert.Equal(t, []string{"a1", "a2"}, sent)
+}
+
+func TestDebouncerFlushSendsWithoutWaiting(t *testing.T) {
+	d := newDebouncer(time.Hour)
+	r := &debounceRecorder{}
+
+	r.schedule(d, "a", "a1")
+	d.flush()
+	r.schedule(d, "b", "b1")
+	r.wg.Wait()
+
+	assert.ElementsMatch(t, []string{"a1", "b1"}, r.sent)
+	assert.Empty(t, r.coalesced)
+}
+
+func TestDebouncerSupersede(t *testing.T) {
+	d := newDebouncer(time.Hour)
+	r := &debounceRecorder{}
+
+	r.schedule(d, "a", "a1")
+	d.supersede("a")
+	d.supersede("b")
+	r.wg.Wait()
+
+	assert.Empty(t, r.sent)
+	assert.Equal(t, []string{"a1"}, r.coalesced)
+}
+
+func TestProcessKafkaMessageWithDebounce(t *testing.T) {
+	uuid := "20db1bd6-59f9-4404-adb5-3165a448f8b0"
+	withConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`
+	noConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"]}]}`
+
+	type testStruct struct {
+		scenarioName        string
+		bodies              []string
+		statusCode          int
+		expectedMethods     []string
+		expectedDeadLetters []string
+	}
+
+	scenarios := []testStruct{
+		{
+			scenarioName:    "burstCoalesced",
+			bodies:          []string{withConcordance, noConcordance, withConcordance},
+			statusCode:      200,
+			expectedMethods: []string{"PUT"},
+		},
+		{
+			scenarioName:        "latestUpdateFailsToWrite",
+			bodies:              []string{withConcordance, noConcordance},
+			statusCode:          503,
+			expectedMethods:     []string{"DELETE"},
+			expectedDeadLetters: []string{noConcordance},
+		},
+		{
+			scenarioName:        "invalidUpdateNotCoalesced",
+			bodies:              []string{withConcordance, `{"@graph": [`},
+			statusCode:          200,
+			expectedMethods:     []string{"PUT"},
+			expectedDeadLetters: []string{`{"@graph": [`},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		client := &recordingHttpClient{statusCodes: []int{scenario.statusCode}}
+		producer := &mockProducer{}
+		h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, client, WithDebounce(time.Hour)), mockConsumer{}, producer)
+
+		for _, body := range scenario.bodies {
+			h.ProcessKafkaMessage(kafka.FTMessage{Headers: map[string]string{"X-Request-Id": "tid_debounce"}, Body: body})
+		}
+		assert.Empty(t, client.methods(uuid), "Scenario: "+scenario.scenarioName+" failed")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		assert.NoError(t, h.AwaitDrained(ctx), "Scenario: "+scenario.scenarioName+" failed")
+		cancel()
+		assert.Equal(t, scenario.expectedMethods, client.methods(uuid), "Scenario: "+scenario.scenarioName+" failed")
+		var deadLetters []string
+		for _, msg := range producer.messages {
+			deadLetters = append(deadLetters, msg.Body)
+		}
+		assert.Equal(t, scenario.expectedDeadLetters, deadLetters, "Scenario: "+scenario.scenarioName+" failed")
+	}
+}
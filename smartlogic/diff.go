@@ -0,0 +1,159 @@
+package smartlogic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConcordanceDiff compares the concordance transformed from a smartlogic payload with the one concordances-rw-neo4j currently holds.
+// Action is the request /transform/send would make: a PUT when there are concordances and a DELETE otherwise.
+type ConcordanceDiff struct {
+	ConceptUuid string        `json:"uuid"`
+	Action      string        `json:"action"`
+	Added       []ConcordedId `json:"added"`
+	Removed     []ConcordedId `json:"removed"`
+	Unchanged   []ConcordedId `json:"unchanged"`
+}
+
+func diffConcordances(proposed UppConcordance, current UppConcordance) ConcordanceDiff {
+	diff := ConcordanceDiff{
+		ConceptUuid: proposed.ConceptUuid,
+		Action:      "DELETE",
+		Added:       []ConcordedId{},
+		Removed:     []ConcordedId{},
+		Unchanged:   []ConcordedId{},
+	}
+	if len(proposed.ConcordedIds) > 0 {
+		diff.Action = "PUT"
+	}
+
+	for _, concordedId := range proposed.ConcordedIds {
+		if containsConcordedId(current.ConcordedIds, concordedId) {
+			diff.Unchanged = append(diff.Unchanged, concordedId)
+		} else {
+			diff.Added = append(diff.Added, concordedId)
+		}
+	}
+	for _, concordedId := range current.ConcordedIds {
+		if !containsConcordedId(proposed.ConcordedIds, concordedId) {
+			diff.Removed = append(diff.Removed, concordedId)
+		}
+	}
+	return diff
+}
+
+// containsConcordedId matches on authority and uuid, as the uuid is derived from the authority value
+func containsConcordedId(concordedIds []ConcordedId, concordedId ConcordedId) bool {
+	for _, c := range concordedIds {
+		if c.Authority == concordedId.Authority && c.UUID == concordedId.UUID {
+			return true
+		}
+	}
+	return false
+}
+
+// getCurrentConcordance reads the concordance concordances-rw-neo4j holds for the concept; a concept it does not know has no concordances.
+func (ts *TransformerService) getCurrentConcordance(ctx context.Context, uuid string, tid string) (status, UppConcordance, error) {
+	reqURL := ts.writerAddress + "branches/" + uuid
+	request, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Internal Error: Failed to create GET request to " + reqURL)
+		return INTERNAL_ERROR, UppConcordance{}, err
+	}
+	request.Header.Set("X-Request-Id", tid)
+
+	resp, err := ts.doWithRetry(request, ts.breaker, uuid, tid)
+	if err != nil {
+		if isTimeout(err) {
+			log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Gateway Timeout: Get request to writer timed out")
+			return TIMEOUT, UppConcordance{}, err
+		}
+		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Service Unavailable: Get request to writer resulted in error")
+		return SERVICE_UNAVAILABLE, UppConcordance{}, err
+	}
+	defer discardBody(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var current UppConcordance
+		if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+			log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Internal Error: Could not decode concordance returned by writer")
+			return INTERNAL_ERROR, UppConcordance{}, err
+		}
+		return VALID_CONCEPT, current, nil
+	case http.StatusNotFound:
+		return NOT_FOUND, UppConcordance{ConceptUuid: uuid, ConcordedIds: []ConcordedId{}}, nil
+	default:
+		err := errors.New("Internal Error: Get request to writer returned unexpected status: " + strconv.Itoa(resp.StatusCode))
+		log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "status": resp.StatusCode}).Error(err)
+		return INTERNAL_ERROR, UppConcordance{}, err
+	}
+}
+
+func (ts *TransformerService) diffConcordance(ctx context.Context, result ConceptResult, tid string) (status, ConcordanceDiff, error) {
+	getStatus, current, err := ts.getCurrentConcordance(ctx, result.ConceptUuid, tid)
+	if err != nil {
+		return getStatus, ConcordanceDiff{}, err
+	}
+	return VALID_CONCEPT, diffConcordances(result.UppConcordance, current), nil
+}
+
+// DiffHandler transforms the payload like TransformHandler and reports how sending it would change what concordances-rw-neo4j holds, without changing anything.
+func (h *SmartlogicConcordanceTransformerHandler) DiffHandler(rw http.ResponseWriter, req *http.Request) {
+	tid := transactionidutils.GetTransactionIDFromRequest(req)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("X-Request-Id", tid)
+	defer req.Body.Close()
+
+	var smartLogicConcept = SmartlogicConcept{}
+	err := json.NewDecoder(req.Body).Decode(&smartLogicConcept)
+	if err != nil {
+		log.WithError(err).WithField("transaction_id", tid).Error("Error whilst processing request body")
+		writeJSONError(rw, "Error whilst processing request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := requestTraceContext(req)
+	updateStatus, results, err := convertToUppConcordances(ctx, smartLogicConcept, tid, validationModeOf(req))
+	if err != nil {
+		writeResponse(rw, updateStatus, err)
+		return
+	}
+
+	diffs := make([]ConcordanceDiff, len(results))
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		results[i].Status, diffs[i], results[i].Err = h.transformer.diffConcordance(ctx, result, tid)
+	}
+
+	if len(results) > 1 {
+		responseStatus := http.StatusOK
+		resp := make([]conceptResultResponse, 0, len(results))
+		for i, result := range results {
+			if result.Err != nil {
+				responseStatus = http.StatusMultiStatus
+				resp = append(resp, newConceptResultResponse(result, false))
+				continue
+			}
+			resp = append(resp, conceptResultResponse{ConceptUuid: result.ConceptUuid, Status: http.StatusOK, Diff: &diffs[i]})
+		}
+		rw.WriteHeader(responseStatus)
+		json.NewEncoder(rw).Encode(resp)
+		return
+	}
+
+	if results[0].Err != nil {
+		writeResponse(rw, results[0].Status, results[0].Err)
+		return
+	}
+	json.NewEncoder(rw).Encode(diffs[0])
+	log.WithFields(log.Fields{"transaction_id": tid, "UUID": results[0].ConceptUuid, "action": diffs[0].Action}).Info("Smartlogic payload compared with concordance held by writer")
+}
@@ -0,0 +1,128 @@
+package smartlogic
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConcordances(t *testing.T) {
+	tme := ConcordedId{Authority: CONCORDANCE_AUTHORITY_TME, AuthorityValue: "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789", UUID: "e9f4525a-401f-3b23-a68e-e48f314cdce6"}
+	factset := ConcordedId{Authority: CONCORDANCE_AUTHORITY_FACTSET, AuthorityValue: "000D63-E", UUID: "8d3aba95-02d9-3802-afc0-b99bb9b1139e"}
+	oldTme := ConcordedId{Authority: CONCORDANCE_AUTHORITY_TME, AuthorityValue: "ZyXwVuTsRqPoNmLkJiHgFeDcBa-0987654321", UUID: "83f63c7e-1641-3c7b-81e4-378ae3c6c2ad"}
+
+	type testStruct struct {
+		testName     string
+		proposed     UppConcordance
+		current      UppConcordance
+		expectedDiff ConcordanceDiff
+	}
+
+	scenarios := []testStruct{
+		{
+			testName: "addedRemovedAndUnchanged",
+			proposed: UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{tme, factset}},
+			current:  UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{oldTme, tme}},
+			expectedDiff: ConcordanceDiff{ConceptUuid: testUuid, Action: "PUT",
+				Added: []ConcordedId{factset}, Removed: []ConcordedId{oldTme}, Unchanged: []ConcordedId{tme}},
+		},
+		{
+			testName: "newConcept",
+			proposed: UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{tme}},
+			current:  UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}},
+			expectedDiff: ConcordanceDiff{ConceptUuid: testUuid, Action: "PUT",
+				Added: []ConcordedId{tme}, Removed: []ConcordedId{}, Unchanged: []ConcordedId{}},
+		},
+		{
+			testName: "allConcordancesRemoved",
+			proposed: UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}},
+			current:  UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{tme}},
+			expectedDiff: ConcordanceDiff{ConceptUuid: testUuid, Action: "DELETE",
+				Added: []ConcordedId{}, Removed: []ConcordedId{tme}, Unchanged: []ConcordedId{}},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		assert.Equal(t, scenario.expectedDiff, diffConcordances(scenario.proposed, scenario.current), "Scenario: "+scenario.testName+" failed")
+	}
+}
+
+func TestDiffHandler(t *testing.T) {
+	current := `{"authority":"Smartlogic","uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","concordances":[{"authority":"TME","authorityValue":"AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789","uuid":"e9f4525a-401f-3b23-a68e-e48f314cdce6"},{"authority":"TME","authorityValue":"OldTmeId-0987654321","uuid":"7d6cbd8b-5da7-3d5b-b0c4-59c27bd2e14b"}]}`
+
+	type testStruct struct {
+		scenarioName       string
+		filePath           string
+		writerStatus       int
+		writerResp         string
+		expectedStatusCode int
+		expectedResult     string
+	}
+
+	scenarios := []testStruct{
+		{
+			scenarioName:       "changedConcordance",
+			filePath:           "../resources/multipleTmeIds.json",
+			writerStatus:       200,
+			writerResp:         current,
+			expectedStatusCode: 200,
+			expectedResult:     `"action":"PUT","added":[{"authority":"TME","authorityValue":"ZyXwVuTsRqPoNmLkJiHgFeDcBa-0987654321","uuid":"83f63c7e-1641-3c7b-81e4-378ae3c6c2ad"},{"authority":"TME","authorityValue":"abcdefghijklmnopqrstuvwxyz-0123456789","uuid":"e4bc4ac2-0637-3a27-86b1-9589fca6bf2c"},{"authority":"TME","authorityValue":"ABCDEFGHIJKLMNOPQRSTUVWXYZ-0987654321","uuid":"e574b21d-9abc-3d82-a6c0-3e08c85181bf"}],"removed":[{"authority":"TME","authorityValue":"OldTmeId-0987654321","uuid":"7d6cbd8b-5da7-3d5b-b0c4-59c27bd2e14b"}],"unchanged":[{"authority":"TME","authorityValue":"AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789","uuid":"e9f4525a-401f-3b23-a68e-e48f314cdce6"}]`,
+		},
+		{
+			scenarioName:       "conceptUnknownToWriter",
+			filePath:           "../resources/noTmeIds.json",
+			writerStatus:       404,
+			expectedStatusCode: 200,
+			expectedResult:     `{"uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","action":"DELETE","added":[],"removed":[],"unchanged":[]}`,
+		},
+		{
+			scenarioName:       "writerError",
+			filePath:           "../resources/noTmeIds.json",
+			writerStatus:       503,
+			expectedStatusCode: 500,
+			expectedResult:     "Get request to writer returned unexpected status: 503",
+		},
+		{
+			scenarioName:       "invalidPayload",
+			filePath:           "../resources/invalidTmeId.json",
+			writerStatus:       200,
+			writerResp:         current,
+			expectedStatusCode: 400,
+			expectedResult:     `"code":"INVALID_IDENTIFIER"`,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		r := mux.NewRouter()
+		h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockHttpClient{resp: scenario.writerResp, statusCode: scenario.writerStatus}), mockConsumer{}, nil)
+		h.RegisterHandlers(r)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, newRequest("POST", "/transform/diff", readFile(t, scenario.filePath)))
+		assert.Equal(t, scenario.expectedStatusCode, rec.Code, scenario.scenarioName)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), scenario.scenarioName)
+		assert.Contains(t, rec.Body.String(), scenario.expectedResult, "Failed scenario: "+scenario.scenarioName)
+	}
+}
+
+func TestDiffHandlerMultipleConcepts(t *testing.T) {
+	r := mux.NewRouter()
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockHttpClient{statusCode: 404}), mockConsumer{}, nil)
+	h.RegisterHandlers(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("POST", "/transform/diff", readFile(t, "../resources/multipleConcepts.json")))
+	assert.Equal(t, 200, rec.Code)
+
+	var resp []conceptResultResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	if assert.Len(t, resp, 2) {
+		assert.Equal(t, "PUT", resp[0].Diff.Action)
+		assert.Len(t, resp[0].Diff.Added, 1)
+		assert.Equal(t, "95f00e25-9a5f-45ec-8ad8-5607d021c74b", resp[1].Diff.ConceptUuid)
+		assert.Equal(t, CONCORDANCE_AUTHORITY_FACTSET, resp[1].Diff.Added[0].Authority)
+	}
+}
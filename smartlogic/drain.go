@@ -0,0 +1,110 @@
+package smartlogic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const drainProgressInterval = 5 * time.Second
+
+// drainer counts the Kafka messages and /transform/send requests being processed so that shutdown can wait for their writes to finish.
+// They run under ctx, which is cancelled to abort their requests to the writer when shutdown stops waiting for them.
+// idle is closed whenever nothing is in flight and replaced by a new channel as soon as something starts again, as Kafka messages
+// keep being delivered while draining.
+type drainer struct {
+	sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+	ctx      context.Context
+	abort    context.CancelFunc
+}
+
+func newDrainer() *drainer {
+	ctx, abort := context.WithCancel(context.Background())
+	idle := make(chan struct{})
+	close(idle)
+	return &drainer{idle: idle, ctx: ctx, abort: abort}
+}
+
+func (d *drainer) start() {
+	d.Lock()
+	defer d.Unlock()
+	if d.inFlight == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.inFlight++
+}
+
+func (d *drainer) finish() {
+	d.Lock()
+	defer d.Unlock()
+	d.inFlight--
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// idleChan returns a channel closed once nothing is in flight
+func (d *drainer) idleChan() chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+	return d.idle
+}
+
+func (d *drainer) isDraining() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.draining
+}
+
+func (d *drainer) current() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.inFlight
+}
+
+func (d *drainer) startDraining() {
+	d.Lock()
+	defer d.Unlock()
+	d.draining = true
+}
+
+// StartDraining makes /__gtg report the service as not good to go and /transform/send refuse new requests, ahead of a shutdown.
+// Kafka messages still delivered by the consumer are processed as normal, except that debounced writes are sent without waiting for their window.
+func (h *SmartlogicConcordanceTransformerHandler) StartDraining() {
+	h.drain.startDraining()
+	h.transformer.debounce.flush()
+	log.WithField("in_flight", h.drain.current()).Info("[Shutdown] Draining: no longer good to go")
+}
+
+// BaseContext is the context Kafka messages are processed under; the HTTP server should use it as the base context of its requests
+// so that the requests to the writer they make are aborted along with those of Kafka messages.
+func (h *SmartlogicConcordanceTransformerHandler) BaseContext() context.Context {
+	return h.drain.ctx
+}
+
+// AwaitDrained waits until the Kafka messages and /transform/send requests in flight have finished, including those that started
+// after an earlier moment when nothing was in flight. When ctx is done first it gives up, aborting the requests to the writer still in flight.
+func (h *SmartlogicConcordanceTransformerHandler) AwaitDrained(ctx context.Context) error {
+	h.drain.startDraining()
+	h.transformer.debounce.flush()
+	ticker := time.NewTicker(drainProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.drain.idleChan():
+			log.Info("[Shutdown] All in-flight messages and requests have finished")
+			return nil
+		case <-ticker.C:
+			log.WithField("in_flight", h.drain.current()).Info("[Shutdown] Waiting for in-flight messages and requests to finish")
+		case <-ctx.Done():
+			log.WithField("in_flight", h.drain.current()).Warn("[Shutdown] Gave up waiting for in-flight messages and requests to finish; aborting their requests to the writer")
+			h.drain.abort()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package smartlogic
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+type blockingHttpClient struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingHttpClient) Do(req *http.Request) (*http.Response, error) {
+	c.started <- struct{}{}
+	select {
+	case <-c.release:
+		return &http.Response{Body: ioutil.NopCloser(bytes.NewReader(nil)), StatusCode: http.StatusOK}, nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+const drainTestMessageBody = `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`
+
+func TestAwaitDrainedWaitsForInFlightMessages(t *testing.T) {
+	client := &blockingHttpClient{started: make(chan struct{}), release: make(chan struct{})}
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, client), mockConsumer{}, nil)
+	msg := kafka.FTMessage{Headers: map[string]string{"X-Request-Id": "tid_drain"}, Body: drainTestMessageBody}
+
+	processed := make(chan error)
+	go func() {
+		processed <- h.ProcessKafkaMessage(msg)
+	}()
+	<-client.started
+
+	h.StartDraining()
+	assert.False(t, h.gtg().GoodToGo)
+	assert.Equal(t, "Service is shutting down", h.gtg().Message)
+
+	drained := make(chan error)
+	go func() {
+		drained <- h.AwaitDrained(context.Background())
+	}()
+	select {
+	case <-drained:
+		t.Fatal("Draining finished before the in-flight write")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(client.release)
+	assert.NoError(t, <-processed)
+	assert.NoError(t, <-drained)
+}
+
+func TestAwaitDrainedWaitsForMessagesStartedAfterAnIdlePoint(t *testing.T) {
+	client := &blockingHttpClient{started: make(chan struct{}), release: make(chan struct{})}
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, client), mockConsumer{}, nil)
+	msg := kafka.FTMessage{Headers: map[string]string{"X-Request-Id": "tid_drain"}, Body: drainTestMessageBody}
+
+	// Nothing is in flight when draining starts, but the consumer still delivers a message before it is shut down
+	h.StartDraining()
+	processed := make(chan error)
+	go func() {
+		processed <- h.ProcessKafkaMessage(msg)
+	}()
+	<-client.started
+
+	drained := make(chan error)
+	go func() {
+		drained <- h.AwaitDrained(context.Background())
+	}()
+	select {
+	case <-drained:
+		t.Fatal("Draining finished before the write of a message started after an idle point")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(client.release)
+	assert.NoError(t, <-processed)
+	assert.NoError(t, <-drained)
+	assert.Equal(t, 0, h.drain.current())
+}
+
+func TestAwaitDrainedAbortsInFlightWritesAtDeadline(t *testing.T) {
+	client := &blockingHttpClient{started: make(chan struct{}), release: make(chan struct{})}
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, client), mockConsumer{}, nil)
+	msg := kafka.FTMessage{Headers: map[string]string{"X-Request-Id": "tid_drain"}, Body: drainTestMessageBody}
+
+	processed := make(chan error)
+	go func() {
+		processed <- h.ProcessKafkaMessage(msg)
+	}()
+	<-client.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, h.AwaitDrained(ctx), "Draining should give up at the deadline while the write is in flight")
+	assert.Equal(t, context.Canceled, <-processed, "The in-flight write should be aborted")
+	assert.Equal(t, context.Canceled, h.BaseContext().Err())
+}
+
+func TestAwaitDrainedWithNothingInFlight(t *testing.T) {
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockHttpClient{statusCode: 200}), mockConsumer{}, nil)
+	assert.True(t, h.gtg().GoodToGo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, h.AwaitDrained(ctx))
+	assert.False(t, h.gtg().GoodToGo)
+}
+
+func TestSendHandlerRefusesRequestsWhileDraining(t *testing.T) {
+	mockClient := mockHttpClient{resp: "", statusCode: 200}
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient), mockConsumer{}, nil)
+	h.StartDraining()
+
+	rec := httptest.NewRecorder()
+	h.SendHandler(rec, newRequest("POST", "/transform/send", `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"]}]}`))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"message": "Service is shutting down"}`, rec.Body.String())
+	assert.Equal(t, 0, h.drain.current())
+}
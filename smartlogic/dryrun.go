@@ -0,0 +1,83 @@
+package smartlogic
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DryRunRequest is a request to concordances-rw-neo4j that was recorded instead of being sent
+type DryRunRequest struct {
+	Time          time.Time       `json:"time"`
+	TransactionID string          `json:"transactionId"`
+	Method        string          `json:"method"`
+	URL           string          `json:"url"`
+	Body          json.RawMessage `json:"body,omitempty"`
+}
+
+// dryRunRecorder keeps the most recent requests that would have been sent to the writer
+type dryRunRecorder struct {
+	sync.Mutex
+	size     int
+	requests []DryRunRequest
+}
+
+func newDryRunRecorder(size int) *dryRunRecorder {
+	if size < 1 {
+		size = 1
+	}
+	return &dryRunRecorder{size: size}
+}
+
+func (r *dryRunRecorder) record(req DryRunRequest) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.requests) == r.size {
+		r.requests = r.requests[1:]
+	}
+	r.requests = append(r.requests, req)
+}
+
+func (r *dryRunRecorder) recorded() []DryRunRequest {
+	r.Lock()
+	defer r.Unlock()
+	requests := make([]DryRunRequest, len(r.requests))
+	copy(requests, r.requests)
+	return requests
+}
+
+// makeDryRunRequest logs and records the requests makeRelevantRequest would have sent to every writer, reporting them as successful
+func (ts *TransformerService) makeDryRunRequest(uuid string, uppConcordance UppConcordance, tid string) (status, error) {
+	req := DryRunRequest{
+		Time:          time.Now().UTC(),
+		TransactionID: tid,
+	}
+	reqStatus := NO_CONTENT
+	if len(uppConcordance.ConcordedIds) > 0 {
+		concordedJson, err := json.Marshal(uppConcordance)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Bad Request: Could not unmarshall concordance json")
+			return SYNTACTICALLY_INCORRECT, err
+		}
+		req.Method = "PUT"
+		req.Body = concordedJson
+		reqStatus = VALID_CONCEPT
+	} else {
+		req.Method = "DELETE"
+	}
+
+	for _, address := range ts.writerAddresses() {
+		req.URL = address + "branches/" + uuid
+		ts.dryRun.record(req)
+		log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "method": req.Method, "url": req.URL, "body": string(req.Body)}).Info("Dry run: request not sent to writer")
+	}
+	return reqStatus, nil
+}
+
+func (h *SmartlogicConcordanceTransformerHandler) DryRunHandler(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(h.transformer.dryRun.recorded())
+}
@@ -0,0 +1,62 @@
+package smartlogic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeRelevantRequestDryRun(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedTmeId}}
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+	client := &recordingHttpClient{}
+	ts := NewTransformerService("", writerUrl, client, WithDryRun(10))
+
+	reqStatus, _, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_put")
+	assert.NoError(t, err)
+	assert.Equal(t, VALID_CONCEPT, reqStatus)
+
+	reqStatus, _, err = ts.makeRelevantRequest(context.Background(), testUuid, noConcordance, "tid_delete")
+	assert.NoError(t, err)
+	assert.Equal(t, NO_CONTENT, reqStatus)
+	assert.Equal(t, 0, client.calls(), "writer should not be called in dry run mode")
+
+	recorded := ts.dryRun.recorded()
+	if assert.Len(t, recorded, 2) {
+		assert.Equal(t, "PUT", recorded[0].Method)
+		assert.Equal(t, writerUrl+"branches/"+testUuid, recorded[0].URL)
+		assert.Equal(t, "tid_put", recorded[0].TransactionID)
+		assert.JSONEq(t, `{"authority":"Smartlogic","uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","concordances":[{"authority":"TME","uuid":"d83a4dc1-397e-4f99-8ecf-2f1b15febb7f"}]}`, string(recorded[0].Body))
+		assert.Equal(t, "DELETE", recorded[1].Method)
+		assert.Equal(t, writerUrl+"branches/"+testUuid, recorded[1].URL)
+		assert.Empty(t, recorded[1].Body)
+	}
+}
+
+func TestDryRunRecorderKeepsMostRecentRequests(t *testing.T) {
+	recorder := newDryRunRecorder(2)
+	recorder.record(DryRunRequest{TransactionID: "tid_1"})
+	recorder.record(DryRunRequest{TransactionID: "tid_2"})
+	recorder.record(DryRunRequest{TransactionID: "tid_3"})
+
+	recorded := recorder.recorded()
+	assert.Equal(t, []DryRunRequest{{TransactionID: "tid_2"}, {TransactionID: "tid_3"}}, recorded)
+}
+
+func TestDryRunHandler(t *testing.T) {
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockHttpClient{}, WithDryRun(10))
+	h := NewHandler(ts, mockConsumer{}, nil)
+	h.transformer.dryRun.record(DryRunRequest{TransactionID: "tid_1", Method: "DELETE", URL: WRITER_ADDRESS + "branches/" + testUuid})
+
+	rec := httptest.NewRecorder()
+	h.DryRunHandler(rec, newRequest("GET", "/__dry-run", ""))
+	assert.Equal(t, 200, rec.Code)
+
+	var recorded []DryRunRequest
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&recorded))
+	assert.Equal(t, "tid_1", recorded[0].TransactionID)
+	assert.Equal(t, "DELETE", recorded[0].Method)
+}
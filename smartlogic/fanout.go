@@ -0,0 +1,174 @@
+package smartlogic
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const primaryWriterName = "concordances-rw-neo4j"
+
+// WriterPolicy tells whether a concordance fails when it cannot be sent to a writer
+type WriterPolicy int
+
+const (
+	// WriterRequired fails the concordance, so that it is dead-lettered and reported as failed, when the writer fails
+	WriterRequired WriterPolicy = iota
+	// WriterBestEffort logs and reports a failure of the writer without failing the concordance
+	WriterBestEffort
+)
+
+func (p WriterPolicy) String() string {
+	if p == WriterBestEffort {
+		return "best-effort"
+	}
+	return "required"
+}
+
+// WriterConfig is a concordances writer that concordances are sent to alongside concordances-rw-neo4j, as PUT and DELETE requests to branches/{uuid}
+type WriterConfig struct {
+	Name    string
+	Address string
+	Policy  WriterPolicy
+}
+
+// WriterOutcome is the outcome of sending a concordance to one of the writers it was fanned out to
+type WriterOutcome struct {
+	Writer string
+	Policy WriterPolicy
+	Status status
+	Err    error
+}
+
+func anyWriterFailed(outcomes []WriterOutcome) bool {
+	for _, outcome := range outcomes {
+		if outcome.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseWriters reads a comma separated list of writers in the form name=address, each optionally followed by ;required, the default, or ;best-effort
+func ParseWriters(spec string) ([]WriterConfig, error) {
+	var writers []WriterConfig
+	names := map[string]bool{primaryWriterName: true}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		writer := WriterConfig{Policy: WriterRequired}
+		if i := strings.LastIndex(entry, ";"); i >= 0 {
+			switch policy := strings.TrimSpace(entry[i+1:]); policy {
+			case "required":
+			case "best-effort":
+				writer.Policy = WriterBestEffort
+			default:
+				return nil, fmt.Errorf("unknown policy %q for writer %q; expected required or best-effort", policy, entry)
+			}
+			entry = entry[:i]
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid writer %q; expected name=address", entry)
+		}
+		writer.Name = strings.TrimSpace(parts[0])
+		writer.Address = strings.TrimSpace(parts[1])
+		if names[writer.Name] {
+			return nil, fmt.Errorf("duplicate writer name %q", writer.Name)
+		}
+		if u, err := url.Parse(writer.Address); err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("invalid address for writer %q; expected an http or https url", writer.Name)
+		}
+		if !strings.HasSuffix(writer.Address, "/") {
+			writer.Address += "/"
+		}
+		names[writer.Name] = true
+		writers = append(writers, writer)
+	}
+	return writers, nil
+}
+
+// WithAdditionalWriters sends every concordance to the given writers as well as to concordances-rw-neo4j, or the concordance topic.
+// The retry policy and writer timeout apply to every writer, but only concordances-rw-neo4j is guarded by the circuit breaker.
+func WithAdditionalWriters(writers []WriterConfig) TransformerOption {
+	return func(ts *TransformerService) {
+		ts.writers = writers
+	}
+}
+
+// fanOutTarget is a sink that concordances are fanned out to
+type fanOutTarget struct {
+	name   string
+	policy WriterPolicy
+	sink   concordanceSink
+}
+
+// fanOutSink sends every concordance to all its targets in parallel. The concordance fails with the first required target that failed;
+// otherwise the status of the first target, which is concordances-rw-neo4j or the concordance topic, is returned.
+type fanOutSink struct {
+	targets []fanOutTarget
+}
+
+func (s fanOutSink) write(ctx context.Context, uuid string, uppConcordance UppConcordance, tid string) (status, []WriterOutcome, error) {
+	return s.send(uuid, tid, "PUT", func(sink concordanceSink) (status, error) {
+		reqStatus, _, err := sink.write(ctx, uuid, uppConcordance, tid)
+		return reqStatus, err
+	})
+}
+
+func (s fanOutSink) delete(ctx context.Context, uuid string, tid string) (status, []WriterOutcome, error) {
+	return s.send(uuid, tid, "DELETE", func(sink concordanceSink) (status, error) {
+		reqStatus, _, err := sink.delete(ctx, uuid, tid)
+		return reqStatus, err
+	})
+}
+
+func (s fanOutSink) send(uuid string, tid string, method string, request func(concordanceSink) (status, error)) (status, []WriterOutcome, error) {
+	outcomes := make([]WriterOutcome, len(s.targets))
+	var wg sync.WaitGroup
+	for i, target := range s.targets {
+		wg.Add(1)
+		go func(i int, target fanOutTarget) {
+			defer wg.Done()
+			reqStatus, err := request(target.sink)
+			outcomes[i] = WriterOutcome{Writer: target.name, Policy: target.policy, Status: reqStatus, Err: err}
+		}(i, target)
+	}
+	wg.Wait()
+
+	var failed *WriterOutcome
+	for i, outcome := range outcomes {
+		observeWriterOutcome(outcome)
+		fields := log.Fields{"transaction_id": tid, "UUID": uuid, "method": method, "writer": outcome.Writer, "policy": outcome.Policy.String(), "status": outcome.Status.String()}
+		switch {
+		case outcome.Err == nil:
+			log.WithFields(fields).Info("Concordance sent to writer")
+		case outcome.Policy == WriterBestEffort:
+			log.WithError(outcome.Err).WithFields(fields).Warn("Concordance could not be sent to best-effort writer; carrying on")
+		default:
+			log.WithError(outcome.Err).WithFields(fields).Error("Concordance could not be sent to required writer")
+			if failed == nil {
+				failed = &outcomes[i]
+			}
+		}
+	}
+	if failed != nil {
+		return failed.Status, outcomes, fmt.Errorf("writer %s: %w", failed.Writer, failed.Err)
+	}
+	return outcomes[0].Status, outcomes, nil
+}
+
+// writerAddresses lists the address of concordances-rw-neo4j followed by those of the additional writers
+func (ts *TransformerService) writerAddresses() []string {
+	addresses := []string{ts.writerAddress}
+	for _, writer := range ts.writers {
+		addresses = append(addresses, writer.Address)
+	}
+	return addresses
+}
@@ -0,0 +1,224 @@
+package smartlogic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	NEW_STORE_ADDRESS = "http://new-store:8080/"
+	ARCHIVE_ADDRESS   = "http://archive:8080/"
+)
+
+// writerHttpClient answers every request with the status code configured for the host of the writer it is sent to
+func writerHttpClient(statusCodes map[string]int) *recordingHttpClient {
+	return &recordingHttpClient{respond: func(req *http.Request) (int, error) {
+		return statusCodes[req.URL.Host], nil
+	}}
+}
+
+func TestParseWriters(t *testing.T) {
+	type testStruct struct {
+		scenarioName    string
+		spec            string
+		expectedWriters []WriterConfig
+		expectedError   string
+	}
+
+	scenarios := []testStruct{
+		{scenarioName: "empty", spec: ""},
+		{scenarioName: "requiredByDefault", spec: "new-store=http://new-store:8080", expectedWriters: []WriterConfig{{Name: "new-store", Address: NEW_STORE_ADDRESS, Policy: WriterRequired}}},
+		{scenarioName: "policies", spec: " new-store=http://new-store:8080/;required , archive=http://archive:8080/;best-effort", expectedWriters: []WriterConfig{
+			{Name: "new-store", Address: NEW_STORE_ADDRESS, Policy: WriterRequired},
+			{Name: "archive", Address: ARCHIVE_ADDRESS, Policy: WriterBestEffort},
+		}},
+		{scenarioName: "unknownPolicy", spec: "new-store=http://new-store:8080/;optional", expectedError: `unknown policy "optional"`},
+		{scenarioName: "missingAddress", spec: "new-store", expectedError: `invalid writer "new-store"`},
+		{scenarioName: "invalidAddress", spec: "new-store=new-store:8080", expectedError: `invalid address for writer "new-store"`},
+		{scenarioName: "duplicateName", spec: "a=http://a/,a=http://b/", expectedError: `duplicate writer name "a"`},
+		{scenarioName: "primaryName", spec: "concordances-rw-neo4j=http://a/", expectedError: `duplicate writer name "concordances-rw-neo4j"`},
+	}
+
+	for _, scenario := range scenarios {
+		writers, err := ParseWriters(scenario.spec)
+		if scenario.expectedError != "" {
+			assert.Error(t, err, "Scenario: "+scenario.scenarioName+" failed")
+			assert.Contains(t, err.Error(), scenario.expectedError, "Scenario: "+scenario.scenarioName+" failed")
+			continue
+		}
+		assert.NoError(t, err, "Scenario: "+scenario.scenarioName+" failed")
+		assert.Equal(t, scenario.expectedWriters, writers, "Scenario: "+scenario.scenarioName+" failed")
+	}
+}
+
+func TestFanOutToWriters(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedTmeId}}
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+	writers := []WriterConfig{
+		{Name: "new-store", Address: NEW_STORE_ADDRESS, Policy: WriterRequired},
+		{Name: "archive", Address: ARCHIVE_ADDRESS, Policy: WriterBestEffort},
+	}
+
+	type testStruct struct {
+		scenarioName     string
+		uppConcordance   UppConcordance
+		statusCodes      map[string]int
+		expectedStatus   status
+		expectedError    string
+		expectedOutcomes []status
+	}
+
+	scenarios := []testStruct{
+		{scenarioName: "allWritten", uppConcordance: withConcordance, statusCodes: map[string]int{"localhost:8080": 200, "new-store:8080": 201, "archive:8080": 200},
+			expectedStatus: VALID_CONCEPT, expectedOutcomes: []status{VALID_CONCEPT, VALID_CONCEPT, VALID_CONCEPT}},
+		{scenarioName: "allDeleted", uppConcordance: noConcordance, statusCodes: map[string]int{"localhost:8080": 204, "new-store:8080": 404, "archive:8080": 204},
+			expectedStatus: NO_CONTENT, expectedOutcomes: []status{NO_CONTENT, NOT_FOUND, NO_CONTENT}},
+		{scenarioName: "bestEffortFails", uppConcordance: withConcordance, statusCodes: map[string]int{"localhost:8080": 200, "new-store:8080": 200, "archive:8080": 503},
+			expectedStatus: VALID_CONCEPT, expectedOutcomes: []status{VALID_CONCEPT, VALID_CONCEPT, INTERNAL_ERROR}},
+		{scenarioName: "requiredFails", uppConcordance: withConcordance, statusCodes: map[string]int{"localhost:8080": 200, "new-store:8080": 503, "archive:8080": 200},
+			expectedStatus: INTERNAL_ERROR, expectedError: "writer new-store: Internal Error: Get request to writer returned unexpected status: 503", expectedOutcomes: []status{VALID_CONCEPT, INTERNAL_ERROR, VALID_CONCEPT}},
+		{scenarioName: "primaryFails", uppConcordance: withConcordance, statusCodes: map[string]int{"localhost:8080": 500, "new-store:8080": 200, "archive:8080": 200},
+			expectedStatus: INTERNAL_ERROR, expectedError: "writer concordances-rw-neo4j: Internal Error: Get request to writer returned unexpected status: 500", expectedOutcomes: []status{INTERNAL_ERROR, VALID_CONCEPT, VALID_CONCEPT}},
+	}
+
+	for _, scenario := range scenarios {
+		client := writerHttpClient(scenario.statusCodes)
+		ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client, WithAdditionalWriters(writers))
+
+		reqStatus, outcomes, err := ts.makeRelevantRequest(context.Background(), testUuid, scenario.uppConcordance, "tid_fanout")
+		assert.Equal(t, scenario.expectedStatus, reqStatus, "Scenario: "+scenario.scenarioName+" failed")
+		if scenario.expectedError != "" {
+			assert.EqualError(t, err, scenario.expectedError, "Scenario: "+scenario.scenarioName+" failed")
+		} else {
+			assert.NoError(t, err, "Scenario: "+scenario.scenarioName+" failed")
+		}
+		assert.Len(t, client.recorded(), 3, "Scenario: "+scenario.scenarioName+" failed")
+
+		var names []string
+		var statuses []status
+		for _, outcome := range outcomes {
+			names = append(names, outcome.Writer)
+			statuses = append(statuses, outcome.Status)
+		}
+		assert.Equal(t, []string{"concordances-rw-neo4j", "new-store", "archive"}, names, "Scenario: "+scenario.scenarioName+" failed")
+		assert.Equal(t, scenario.expectedOutcomes, statuses, "Scenario: "+scenario.scenarioName+" failed")
+	}
+}
+
+func TestFanOutRetriesWritersThatFailedDespiteWriteCache(t *testing.T) {
+	writers := []WriterConfig{{Name: "archive", Address: ARCHIVE_ADDRESS, Policy: WriterBestEffort}}
+	statusCodes := map[string]int{"localhost:8080": 200, "archive:8080": 503}
+	client := writerHttpClient(statusCodes)
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client, WithAdditionalWriters(writers), WithWriteCache(10, time.Hour))
+	withConcordance := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedTmeId}}
+
+	reqStatus, _, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_fanout")
+	assert.NoError(t, err)
+	assert.Equal(t, VALID_CONCEPT, reqStatus)
+
+	statusCodes["archive:8080"] = 200
+	reqStatus, outcomes, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_fanout")
+	assert.NoError(t, err)
+	assert.Equal(t, VALID_CONCEPT, reqStatus, "A concordance a best-effort writer failed to write should not be skipped as unchanged")
+	assert.Len(t, outcomes, 2)
+	assert.Equal(t, 4, client.calls())
+
+	reqStatus, _, err = ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_fanout")
+	assert.NoError(t, err)
+	assert.Equal(t, UNCHANGED, reqStatus, "A concordance every writer has should be skipped as unchanged")
+	assert.Equal(t, 4, client.calls())
+}
+
+func TestFanOutWithKafkaSink(t *testing.T) {
+	producer := &mockConcordanceProducer{}
+	client := writerHttpClient(map[string]int{"new-store:8080": 200})
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client, WithKafkaSink(producer, "Concordances"),
+		WithAdditionalWriters([]WriterConfig{{Name: "new-store", Address: NEW_STORE_ADDRESS, Policy: WriterRequired}}))
+
+	withConcordance := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedTmeId}}
+	reqStatus, outcomes, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_fanout")
+	assert.NoError(t, err)
+	assert.Equal(t, VALID_CONCEPT, reqStatus)
+	assert.Len(t, producer.messages, 1)
+	assert.Equal(t, []string{"PUT " + NEW_STORE_ADDRESS + "branches/" + testUuid}, client.recorded())
+	assert.Equal(t, "Concordances", outcomes[0].Writer)
+}
+
+func TestFanOutDryRun(t *testing.T) {
+	client := writerHttpClient(nil)
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client, WithDryRun(10),
+		WithAdditionalWriters([]WriterConfig{{Name: "new-store", Address: NEW_STORE_ADDRESS, Policy: WriterRequired}}))
+
+	withConcordance := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedTmeId}}
+	_, _, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_fanout")
+	assert.NoError(t, err)
+	assert.Empty(t, client.recorded())
+	var urls []string
+	for _, req := range ts.dryRun.recorded() {
+		urls = append(urls, req.URL)
+	}
+	assert.Equal(t, []string{WRITER_ADDRESS + "branches/" + testUuid, NEW_STORE_ADDRESS + "branches/" + testUuid}, urls)
+}
+
+func TestSendHandlerReportsWriterOutcomes(t *testing.T) {
+	writers := []WriterConfig{
+		{Name: "new-store", Address: NEW_STORE_ADDRESS, Policy: WriterRequired},
+		{Name: "archive", Address: ARCHIVE_ADDRESS, Policy: WriterBestEffort},
+	}
+
+	type testStruct struct {
+		scenarioName       string
+		statusCodes        map[string]int
+		expectedStatusCode int
+		expectedBody       string
+	}
+
+	scenarios := []testStruct{
+		{scenarioName: "bestEffortFails", statusCodes: map[string]int{"localhost:8080": 200, "new-store:8080": 200, "archive:8080": 503}, expectedStatusCode: 200,
+			expectedBody: `{"message":"Concordance record forwarded to writer","writers":[` +
+				`{"writer":"concordances-rw-neo4j","policy":"required","status":200,"message":"Concordance record forwarded to writer"},` +
+				`{"writer":"new-store","policy":"required","status":200,"message":"Concordance record forwarded to writer"},` +
+				`{"writer":"archive","policy":"best-effort","status":500,"message":"Internal Error: Get request to writer returned unexpected status: 503"}]}`},
+		{scenarioName: "requiredFails", statusCodes: map[string]int{"localhost:8080": 200, "new-store:8080": 503, "archive:8080": 200}, expectedStatusCode: 500,
+			expectedBody: `{"message":"writer new-store: Internal Error: Get request to writer returned unexpected status: 503","writers":[` +
+				`{"writer":"concordances-rw-neo4j","policy":"required","status":200,"message":"Concordance record forwarded to writer"},` +
+				`{"writer":"new-store","policy":"required","status":500,"message":"Internal Error: Get request to writer returned unexpected status: 503"},` +
+				`{"writer":"archive","policy":"best-effort","status":200,"message":"Concordance record forwarded to writer"}]}`},
+	}
+
+	for _, scenario := range scenarios {
+		r := mux.NewRouter()
+		client := writerHttpClient(scenario.statusCodes)
+		h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, client, WithAdditionalWriters(writers)), mockConsumer{}, nil)
+		h.RegisterHandlers(r)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, newRequest("POST", "/transform/send", readFile(t, "../resources/multipleTmeIds.json")))
+		assert.Equal(t, scenario.expectedStatusCode, rec.Code, "Scenario: "+scenario.scenarioName+" failed")
+		assert.JSONEq(t, scenario.expectedBody, rec.Body.String(), "Scenario: "+scenario.scenarioName+" failed")
+	}
+}
+
+func TestWriterHealthChecks(t *testing.T) {
+	writers := []WriterConfig{
+		{Name: "new-store", Address: NEW_STORE_ADDRESS, Policy: WriterRequired},
+		{Name: "archive", Address: ARCHIVE_ADDRESS, Policy: WriterBestEffort},
+	}
+	statusCodes := map[string]int{"localhost:8080": 200, "new-store:8080": 200, "archive:8080": 503}
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, writerHttpClient(statusCodes), WithAdditionalWriters(writers)), mockConsumer{}, nil)
+
+	_, err := h.checkWriterConnectivity(writers[1])
+	assert.Equal(t, errors.New("Unable to verify availability of archive"), err)
+	assert.True(t, h.gtg().GoodToGo, "A best-effort writer being down should not stop the service being good to go")
+
+	statusCodes["new-store:8080"] = 503
+	assert.False(t, h.gtg().GoodToGo, "A required writer being down should stop the service being good to go")
+	assert.Equal(t, "Unable to verify availability of new-store", h.gtg().Message)
+}
@@ -2,37 +2,123 @@ package smartlogic
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
-	"fmt"
-
 	"github.com/Financial-Times/kafka-client-go/kafka"
 	"github.com/Financial-Times/transactionid-utils-go"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SmartlogicConcordanceTransformerHandler struct {
-	transformer TransformerService
-	consumer    kafka.Consumer
+	transformer        TransformerService
+	consumer           kafka.Consumer
+	deadLetterProducer kafka.Producer
+	drain              *drainer
+	workers            *conceptWorkerPool
+	auth               *Authenticator
+}
+
+type HandlerOption func(*SmartlogicConcordanceTransformerHandler)
+
+// WithConcurrency processes Kafka messages on the given number of workers, each with a queue of queueSize messages.
+// Messages for the same concept are always processed by the same worker, in the order they were consumed.
+// A message is acknowledged once it is queued, so delivery becomes at most once: the messages queued or being processed
+// when the process dies are lost, where one processed as it is consumed would be consumed again.
+// With fewer than two workers messages are processed one at a time as they are consumed.
+func WithConcurrency(workers int, queueSize int) HandlerOption {
+	return func(h *SmartlogicConcordanceTransformerHandler) {
+		if workers > 1 {
+			h.workers = newConceptWorkerPool(workers, queueSize, kafkaMessagesQueued)
+		}
+	}
 }
 
-func NewHandler(transformer TransformerService, consumer kafka.Consumer) SmartlogicConcordanceTransformerHandler {
-	return SmartlogicConcordanceTransformerHandler{
-		transformer: transformer,
-		consumer:    consumer,
+// NewHandler creates the handler for Kafka messages and the HTTP endpoints; deadLetterProducer may be nil, in which case failed messages are only logged.
+func NewHandler(transformer TransformerService, consumer kafka.Consumer, deadLetterProducer kafka.Producer, opts ...HandlerOption) SmartlogicConcordanceTransformerHandler {
+	h := SmartlogicConcordanceTransformerHandler{
+		transformer:        transformer,
+		consumer:           consumer,
+		deadLetterProducer: deadLetterProducer,
+		drain:              newDrainer(),
+	}
+	for _, opt := range opts {
+		opt(&h)
 	}
+	return h
 }
 
+// ProcessKafkaMessage transforms a Smartlogic message and sends its concordances to the writer. With a worker pool the message is only
+// queued on the workers for its concepts, blocking while a queue is full, and failures are reported through the dead-letter topic.
+// The message is then acknowledged before its concordances are written.
 func (h *SmartlogicConcordanceTransformerHandler) ProcessKafkaMessage(msg kafka.FTMessage) error {
+	h.drain.start()
+	kafkaMessagesConsumed.Inc()
 	var tid string
 	if msg.Headers["X-Request-Id"] == "" {
 		tid = transactionidutils.NewTransactionID()
 	} else {
 		tid = msg.Headers["X-Request-Id"]
 	}
-	return h.transformer.handleConcordanceEvent(msg.Body, tid)
+	if h.workers == nil {
+		return h.processKafkaMessage(msg, tid, h.drain.finish)
+	}
+
+	defer h.drain.finish()
+	var queueErr error
+	for _, part := range h.workers.split(msg.Body) {
+		partMsg := kafka.NewFTMessage(msg.Headers, part.body)
+		h.drain.start()
+		err := h.workers.submitTo(h.drain.ctx, part.worker, func() {
+			h.processKafkaMessage(partMsg, tid, h.drain.finish)
+		})
+		if err != nil {
+			h.drain.finish()
+			log.WithError(err).WithField("transaction_id", tid).Error("Failed to queue Kafka message for processing")
+			h.publishDeadLetter(partMsg, tid, SERVICE_UNAVAILABLE, err)
+			if queueErr == nil {
+				queueErr = err
+			}
+		}
+	}
+	return queueErr
+}
+
+// processKafkaMessage calls finished once the message has been dealt with. When writes are debounced that is after its concordances have been
+// written or coalesced, and failures to write them are reported through the dead-letter topic only.
+func (h *SmartlogicConcordanceTransformerHandler) processKafkaMessage(msg kafka.FTMessage, tid string, finished func()) error {
+	ctx := withAuditSource(h.drain.ctx, AuditSource{Type: "kafka", Topic: h.transformer.topic, MessageID: msg.Headers["Message-Id"]})
+	ctx, span := startSpan(kafkaTraceContext(ctx, msg.Headers), "ProcessKafkaMessage", trace.SpanKindConsumer,
+		attribute.String("transaction_id", tid),
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination", h.transformer.topic),
+	)
+	h.transformer.breaker.waitUntilClosed(ctx, h.transformer.probeWriter, tid)
+	var failureStatus status
+	var err error
+	if h.transformer.debounce == nil {
+		failureStatus, _, err = h.transformer.handleConcordanceEvent(ctx, msg.Body, tid)
+	} else {
+		failureStatus, err = h.transformer.handleDebouncedConcordanceEvent(ctx, msg.Body, tid, func(results []ConceptResult) {
+			if err := failedResultsError(results); err != nil {
+				h.publishDeadLetter(msg, tid, failedResultsStatus(results), err)
+			}
+			finished()
+		})
+	}
+	if err != nil {
+		h.publishDeadLetter(msg, tid, failureStatus, err)
+		span.SetAttributes(attribute.String("status", failureStatus.String()))
+	}
+	endSpan(span, err)
+	if h.transformer.debounce == nil || err != nil {
+		finished()
+	}
+	return err
 }
 
 func (h *SmartlogicConcordanceTransformerHandler) RegisterHandlers(router *mux.Router) {
@@ -40,11 +126,19 @@ func (h *SmartlogicConcordanceTransformerHandler) RegisterHandlers(router *mux.R
 	transformAndWrite := handlers.MethodHandler{
 		"POST": http.HandlerFunc(h.SendHandler),
 	}
-	router.Handle("/transform/send", transformAndWrite)
+	router.Handle("/transform/send", h.requireRole(RoleWrite, transformAndWrite))
 	transformAndReturn := handlers.MethodHandler{
 		"POST": http.HandlerFunc(h.TransformHandler),
 	}
 	router.Handle("/transform", transformAndReturn)
+	transformBatch := handlers.MethodHandler{
+		"POST": http.HandlerFunc(h.BatchHandler),
+	}
+	router.Handle("/transform/batch", transformBatch)
+	transformAndDiff := handlers.MethodHandler{
+		"POST": http.HandlerFunc(h.DiffHandler),
+	}
+	router.Handle("/transform/diff", transformAndDiff)
 }
 
 func (h *SmartlogicConcordanceTransformerHandler) TransformHandler(rw http.ResponseWriter, req *http.Request) {
@@ -57,13 +151,12 @@ func (h *SmartlogicConcordanceTransformerHandler) TransformHandler(rw http.Respo
 
 	if err != nil {
 		log.WithError(err).WithField("transaction_id", tid).Error("Error whilst processing request body")
-		rw.WriteHeader(http.StatusBadRequest)
-		rw.Write([]byte("{\"message\":\"Error whilst processing request body: " + err.Error() + "\"}"))
+		writeJSONError(rw, "Error whilst processing request body: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	log.WithField("transaction_id", tid).Debug("Processing concordance transformation")
-	updateStatus, conceptUuid, uppConcordance, err := convertToUppConcordance(smartLogicConcept, tid)
+	updateStatus, results, err := convertToUppConcordances(requestTraceContext(req), smartLogicConcept, tid, validationModeOf(req))
 
 	if err != nil {
 		writeResponse(rw, updateStatus, err)
@@ -71,8 +164,20 @@ func (h *SmartlogicConcordanceTransformerHandler) TransformHandler(rw http.Respo
 	}
 	defer req.Body.Close()
 
-	json.NewEncoder(rw).Encode(uppConcordance)
-	log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid, "status": http.StatusOK}).Info("Smartlogic payload successfully transformed")
+	if len(results) > 1 {
+		writeResults(rw, results, true)
+		log.WithFields(log.Fields{"transaction_id": tid, "concepts": len(results)}).Info("Smartlogic payload with multiple concepts transformed")
+		return
+	}
+
+	result := results[0]
+	if result.Err != nil {
+		writeResponse(rw, result.Status, result.Err)
+		return
+	}
+
+	json.NewEncoder(rw).Encode(result.UppConcordance)
+	log.WithFields(log.Fields{"transaction_id": tid, "UUID": result.ConceptUuid, "status": http.StatusOK}).Info("Smartlogic payload successfully transformed")
 	return
 }
 
@@ -81,70 +186,220 @@ func (h *SmartlogicConcordanceTransformerHandler) SendHandler(rw http.ResponseWr
 	rw.Header().Set("Content-Type", "application/json")
 	rw.Header().Set("X-Request-Id", tid)
 
+	h.drain.start()
+	defer h.drain.finish()
+	if h.drain.isDraining() {
+		log.WithField("transaction_id", tid).Warn("Refusing request to send concordance as the service is shutting down")
+		writeJSONError(rw, "Service is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	var smartLogicConcept = SmartlogicConcept{}
 	err := json.NewDecoder(req.Body).Decode(&smartLogicConcept)
 
 	if err != nil {
 		log.WithError(err).WithField("transaction_id", tid).Error("Error whilst processing request body")
-		rw.WriteHeader(http.StatusBadRequest)
-		rw.Write([]byte("{\"message\":\"Error whilst processing request body:" + err.Error() + "\"}"))
+		writeJSONError(rw, "Error whilst processing request body: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	log.WithField("transaction_id", tid).Debug("Processing concordance transformation")
-	updateStatus, conceptUuid, uppConcordance, err := convertToUppConcordance(smartLogicConcept, tid)
+	ctx := withAuditSource(requestTraceContext(req), AuditSource{Type: "http", Principal: principalFrom(req.Context()), RemoteAddr: req.RemoteAddr})
+	updateStatus, results, err := convertToUppConcordances(ctx, smartLogicConcept, tid, failFast)
 
 	if err != nil {
 		writeResponse(rw, updateStatus, err)
+		return
 	}
+	defer req.Body.Close()
 
-	updateStatus, err = h.transformer.makeRelevantRequest(conceptUuid, uppConcordance, tid)
+	h.transformer.forwardConcordances(ctx, results, tid)
 
-	if err != nil {
-		writeResponse(rw, updateStatus, err)
+	if len(results) > 1 {
+		writeResults(rw, results, false)
+		log.WithFields(log.Fields{"transaction_id": tid, "concepts": len(results)}).Info("Smartlogic payload with multiple concepts forwarded to writer")
 		return
 	}
-	defer req.Body.Close()
 
-	var logMsg string
-	if updateStatus == VALID_CONCEPT {
-		logMsg = "Concordance record forwarded to writer"
-		rw.WriteHeader(http.StatusOK)
-		rw.Write([]byte("{\"message\":\"" + logMsg + "\"}"))
+	result := results[0]
+	if result.Err != nil && len(result.Writers) > 0 {
+		rw.WriteHeader(httpStatus(result.Status))
+		json.NewEncoder(rw).Encode(sendResponse{Message: result.Err.Error(), Writers: newWriterOutcomeResponses(result.Writers)})
+		return
+	}
+	if result.Err != nil {
+		writeResponse(rw, result.Status, result.Err)
 		return
-	} else if updateStatus == NO_CONTENT {
-		logMsg = "Concordance record successfuly deleted"
-		rw.Write([]byte("{\"message\":\"" + logMsg + "\"}"))
-	} else if updateStatus == NOT_FOUND {
-		logMsg = "Concordance record not found"
-		rw.Write([]byte("{\"message\":\"" + logMsg + "\"}"))
 	}
-	log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid, "status": http.StatusOK}).Info(logMsg)
 
+	logMsg := successMessage(result.Status)
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(sendResponse{Message: logMsg, Writers: newWriterOutcomeResponses(result.Writers)})
+	log.WithFields(log.Fields{"transaction_id": tid, "UUID": result.ConceptUuid, "status": http.StatusOK}).Info(logMsg)
 	return
 }
 
-func writeResponse(rw http.ResponseWriter, updateStatus status, err error) {
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+// sendResponse reports a concordance sent to the writer, with the outcome of every writer when it was fanned out to several writers
+type sendResponse struct {
+	Message string                  `json:"message"`
+	Writers []writerOutcomeResponse `json:"writers,omitempty"`
+}
+
+type writerOutcomeResponse struct {
+	Writer  string `json:"writer"`
+	Policy  string `json:"policy"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type validationErrorsResponse struct {
+	Message string           `json:"message"`
+	Errors  ValidationErrors `json:"errors"`
+}
+
+type conceptResultResponse struct {
+	ConceptUuid    string                  `json:"uuid,omitempty"`
+	Status         int                     `json:"status"`
+	Message        string                  `json:"message,omitempty"`
+	Code           ValidationCode          `json:"code,omitempty"`
+	Authority      string                  `json:"authority,omitempty"`
+	Value          string                  `json:"value,omitempty"`
+	Predicate      string                  `json:"predicate,omitempty"`
+	Errors         ValidationErrors        `json:"errors,omitempty"`
+	UppConcordance *UppConcordance         `json:"concordance,omitempty"`
+	Diff           *ConcordanceDiff        `json:"diff,omitempty"`
+	Writers        []writerOutcomeResponse `json:"writers,omitempty"`
+}
+
+// writeResults reports the outcome of every concept in a multi-concept payload; the response is 200 when all succeeded and 207 otherwise.
+func writeResults(rw http.ResponseWriter, results []ConceptResult, includeConcordance bool) {
+	responseStatus := http.StatusOK
+	resp := make([]conceptResultResponse, 0, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			responseStatus = http.StatusMultiStatus
+		}
+		resp = append(resp, newConceptResultResponse(result, includeConcordance))
+	}
+	rw.WriteHeader(responseStatus)
+	json.NewEncoder(rw).Encode(resp)
+}
+
+func newConceptResultResponse(result ConceptResult, includeConcordance bool) conceptResultResponse {
+	if result.Err != nil {
+		r := newErrorResponse(result.Status, result.Err)
+		r.ConceptUuid = result.ConceptUuid
+		r.Writers = newWriterOutcomeResponses(result.Writers)
+		return r
+	}
+	r := conceptResultResponse{ConceptUuid: result.ConceptUuid, Status: http.StatusOK, Writers: newWriterOutcomeResponses(result.Writers)}
+	if includeConcordance {
+		uppConcordance := result.UppConcordance
+		r.UppConcordance = &uppConcordance
+	} else {
+		r.Message = successMessage(result.Status)
+	}
+	return r
+}
+
+func newWriterOutcomeResponses(outcomes []WriterOutcome) []writerOutcomeResponse {
+	if len(outcomes) == 0 {
+		return nil
+	}
+	resp := make([]writerOutcomeResponse, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		r := writerOutcomeResponse{Writer: outcome.Writer, Policy: outcome.Policy.String(), Status: http.StatusOK, Message: successMessage(outcome.Status)}
+		if outcome.Err != nil {
+			r.Status = httpStatus(outcome.Status)
+			r.Message = outcome.Err.Error()
+		}
+		resp = append(resp, r)
+	}
+	return resp
+}
+
+func newErrorResponse(updateStatus status, err error) conceptResultResponse {
+	r := conceptResultResponse{Status: httpStatus(updateStatus), Message: err.Error()}
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		r.Errors = validationErrs
+		return r
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		r.Code = validationErr.Code
+		r.Authority = validationErr.Authority
+		r.Value = validationErr.Value
+		r.Predicate = validationErr.Predicate
+	}
+	return r
+}
+
+// validationModeOf returns collectAll when the request asks for every validation problem with ?validation=all
+func validationModeOf(req *http.Request) validationMode {
+	if req.URL.Query().Get("validation") == "all" {
+		return collectAll
+	}
+	return failFast
+}
+
+func successMessage(updateStatus status) string {
+	switch updateStatus {
+	case NO_CONTENT:
+		return "Concordance record successfuly deleted"
+	case NOT_FOUND:
+		return "Concordance record not found"
+	case UNCHANGED:
+		return "Concordance record unchanged; not forwarded to writer"
+	default:
+		return "Concordance record forwarded to writer"
+	}
+}
+
+func httpStatus(updateStatus status) int {
 	switch updateStatus {
 	case SYNTACTICALLY_INCORRECT:
-		writeJSONError(rw, err.Error(), http.StatusBadRequest)
-		return
+		return http.StatusBadRequest
 	case SEMANTICALLY_INCORRECT:
-		writeJSONError(rw, err.Error(), http.StatusUnprocessableEntity)
-		return
+		return http.StatusUnprocessableEntity
 	case SERVICE_UNAVAILABLE:
-		writeJSONError(rw, err.Error(), http.StatusServiceUnavailable)
+		return http.StatusServiceUnavailable
+	case TIMEOUT:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeResponse reports a failed request; validation errors are returned with all their details so that clients can tell failures apart.
+func writeResponse(rw http.ResponseWriter, updateStatus status, err error) {
+	statusCode := httpStatus(updateStatus)
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		rw.WriteHeader(statusCode)
+		json.NewEncoder(rw).Encode(validationErrorsResponse{Message: validationErrs.Error(), Errors: validationErrs})
 		return
-	case INTERNAL_ERROR:
-		writeJSONError(rw, err.Error(), http.StatusInternalServerError)
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		rw.WriteHeader(statusCode)
+		json.NewEncoder(rw).Encode(validationErr)
 		return
+	}
+
+	switch updateStatus {
+	case SYNTACTICALLY_INCORRECT, SEMANTICALLY_INCORRECT, SERVICE_UNAVAILABLE, TIMEOUT, INTERNAL_ERROR:
+		writeJSONError(rw, err.Error(), statusCode)
 	default:
-		writeJSONError(rw, "Unknown error", http.StatusInternalServerError)
-		return
+		writeJSONError(rw, "Unknown error", statusCode)
 	}
 }
 
 func writeJSONError(w http.ResponseWriter, errorMsg string, statusCode int) {
 	w.WriteHeader(statusCode)
-	fmt.Fprintln(w, fmt.Sprintf("{\"message\": \"%s\"}", errorMsg))
+	json.NewEncoder(w).Encode(messageResponse{Message: errorMsg})
 }
@@ -2,11 +2,18 @@ package smartlogic
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/Financial-Times/kafka-client-go/kafka"
@@ -29,7 +36,7 @@ type mockHttpClient struct {
 func TestProcessKafkaMessage(t *testing.T) {
 	mockClient := mockHttpClient{resp: "", statusCode: 200}
 	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
-	h := NewHandler(defaultTransformer, mockConsumer{})
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
 
 	type testStruct struct {
 		scenarioName  string
@@ -42,7 +49,7 @@ func TestProcessKafkaMessage(t *testing.T) {
 	validJsonLdWithConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`
 
 	failOnInvalidKafkaMessagePayload := testStruct{scenarioName: "failOnInvalidKafkaMessagePayload", payload: kafka.FTMessage{Body: ""}, expectedError: errors.New("EOF")}
-	failOnInvalidJsonLdInPayload := testStruct{scenarioName: "failOnInvalidJsonLdInPayload", payload: kafka.FTMessage{Body: invalidJsonLd, Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedError: errors.New("Invalid Request Json: More than 1 concept in smartlogic concept payload which is currently not supported")}
+	failOnInvalidJsonLdInPayload := testStruct{scenarioName: "failOnInvalidJsonLdInPayload", payload: kafka.FTMessage{Body: invalidJsonLd, Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedError: errors.New("2 of 2 concepts in smartlogic concept payload could not be processed: Bad Request: Type has not been set for concept: 20db1bd6-59f9-4404-adb5-3165a448f8b0); Bad Request: Type has not been set for concept: 20db1bd6-59f9-4404-adb5-3165a448f8b0)")}
 	failOnWritePayloadToWriter := testStruct{scenarioName: "failOnWritePayloadToWriter", payload: kafka.FTMessage{Body: validJsonLdNoConcordance, Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedError: errors.New("Internal Error: Delete request to writer returned unexpected status: 200")}
 	successfulRequest := testStruct{scenarioName: "successfulRequest", payload: kafka.FTMessage{Body: validJsonLdWithConcordance, Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedError: nil}
 
@@ -55,11 +62,47 @@ func TestProcessKafkaMessage(t *testing.T) {
 
 }
 
+func TestProcessKafkaMessagePublishesFailuresToDeadLetterTopic(t *testing.T) {
+	mockClient := mockHttpClient{resp: "", statusCode: 503}
+	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
+	producer := &mockProducer{}
+	h := NewHandler(defaultTransformer, mockConsumer{}, producer)
+
+	type testStruct struct {
+		scenarioName          string
+		payload               kafka.FTMessage
+		expectedFailureStatus string
+	}
+
+	invalidTmeId := `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "invalid"}]}]}`
+	validJsonLdWithConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`
+
+	scenarios := []testStruct{
+		{scenarioName: "invalidJson", payload: kafka.FTMessage{Body: "{", Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedFailureStatus: "SYNTACTICALLY_INCORRECT"},
+		{scenarioName: "missingGraph", payload: kafka.FTMessage{Body: "{}", Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedFailureStatus: "SEMANTICALLY_INCORRECT"},
+		{scenarioName: "invalidTmeId", payload: kafka.FTMessage{Body: invalidTmeId, Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedFailureStatus: "SYNTACTICALLY_INCORRECT"},
+		{scenarioName: "writerError", payload: kafka.FTMessage{Body: validJsonLdWithConcordance, Headers: map[string]string{"X-Request-Id": "test_tid"}}, expectedFailureStatus: "INTERNAL_ERROR"},
+	}
+
+	for _, scenario := range scenarios {
+		producer.messages = nil
+		err := h.ProcessKafkaMessage(scenario.payload)
+		assert.Error(t, err, scenario.scenarioName)
+		if assert.Len(t, producer.messages, 1, scenario.scenarioName) {
+			msg := producer.messages[0]
+			assert.Equal(t, scenario.payload.Body, msg.Body, scenario.scenarioName)
+			assert.Equal(t, "test_tid", msg.Headers["X-Request-Id"], scenario.scenarioName)
+			assert.Equal(t, scenario.expectedFailureStatus, msg.Headers["Failure-Status"], scenario.scenarioName)
+			assert.Equal(t, err.Error(), msg.Headers["Failure-Reason"], scenario.scenarioName)
+		}
+	}
+}
+
 func TestTransformAndSendHandlers(t *testing.T) {
 	r := mux.NewRouter()
 	mockClient := mockHttpClient{resp: "", statusCode: 200}
 	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
-	h := NewHandler(defaultTransformer, mockConsumer{})
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
 	h.RegisterHandlers(r)
 
 	type testStruct struct {
@@ -70,10 +113,12 @@ func TestTransformAndSendHandlers(t *testing.T) {
 		expectedResult     string
 	}
 
-	transform_unprocessibleEntityError := testStruct{scenarioName: "transform_unprocessibleEntityError", filePath: "../resources/multipleGraphsInList.json", endpoint: "/transform", expectedStatusCode: 422, expectedResult: "Invalid Request Json: More than 1 concept in smartlogic concept payload which is currently not supported"}
-	transform_convertingToConcordedJsonError := testStruct{scenarioName: "transform_convertingToConcordedJsonError", filePath: "../resources/invalidTmeId.json", endpoint: "/transform", expectedStatusCode: 400, expectedResult: "is not a valid TME Id"}
+	transform_multipleConceptsError := testStruct{scenarioName: "transform_multipleConceptsError", filePath: "../resources/multipleGraphsInList.json", endpoint: "/transform", expectedStatusCode: 207, expectedResult: `{"uuid":"95f00e25-9a5f-45ec-8ad8-5607d021c74b","status":400,"message":"Bad Request: Type has not been set for concept: 95f00e25-9a5f-45ec-8ad8-5607d021c74b)","code":"MISSING_CONCEPT_TYPE"}`}
+	transform_convertingToConcordedJsonError := testStruct{scenarioName: "transform_convertingToConcordedJsonError", filePath: "../resources/invalidTmeId.json", endpoint: "/transform", expectedStatusCode: 400, expectedResult: `{"code":"INVALID_IDENTIFIER","message":"Bad Request: Concordance id AbCdEf-gHiJkLMnOpQ-rStUvXyZ-0123456789 is not a valid TME Id","uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","authority":"TME","value":"AbCdEf-gHiJkLMnOpQ-rStUvXyZ-0123456789","predicate":"http://www.ft.com/ontology/TMEIdentifier"}`}
 	transform_duplicateTmeIdsError := testStruct{scenarioName: "transform_duplicateTmeIdsError", filePath: "../resources/duplicateTmeIds.json", endpoint: "/transform", expectedStatusCode: 400, expectedResult: "contains duplicate TME id values"}
-	send_unprocessibleEntityError := testStruct{scenarioName: "send_unprocessibleEntityError", filePath: "../resources/multipleGraphsInList.json", endpoint: "/transform/send", expectedStatusCode: 422, expectedResult: "Invalid Request Json: More than 1 concept in smartlogic concept payload which is currently not supported"}
+	send_multipleConceptsError := testStruct{scenarioName: "send_multipleConceptsError", filePath: "../resources/multipleGraphsInList.json", endpoint: "/transform/send", expectedStatusCode: 207, expectedResult: `{"uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","status":400,"message":"Bad Request: Type has not been set for concept: 20db1bd6-59f9-4404-adb5-3165a448f8b0)","code":"MISSING_CONCEPT_TYPE"}`}
+	transform_unprocessibleEntityError := testStruct{scenarioName: "transform_unprocessibleEntityError", filePath: "../resources/missingIdField.json", endpoint: "/transform", expectedStatusCode: 422, expectedResult: `{"code":"MISSING_GRAPH","message":"Invalid Request Json: Missing/invalid @graph field"}`}
+	send_unprocessibleEntityError := testStruct{scenarioName: "send_unprocessibleEntityError", filePath: "../resources/missingIdField.json", endpoint: "/transform/send", expectedStatusCode: 422, expectedResult: "Invalid Request Json: Missing/invalid @graph field"}
 	send_convertingToConcordedJsonError := testStruct{scenarioName: "send_convertingToConcordedJsonError", filePath: "../resources/invalidTmeId.json", endpoint: "/transform/send", expectedStatusCode: 400, expectedResult: "is not a valid TME Id"}
 	send_convertsAndFailsForwardToRw := testStruct{scenarioName: "send_convertsAndFailsForwardToRw", filePath: "../resources/noTmeIds.json", endpoint: "/transform/send", expectedStatusCode: 500, expectedResult: "Internal Error: Delete request to writer returned unexpected status:"}
 
@@ -82,7 +127,7 @@ func TestTransformAndSendHandlers(t *testing.T) {
 		filePath:           "../resources/notAllowedType.json",
 		endpoint:           "/transform",
 		expectedStatusCode: 422,
-		expectedResult:     "concept type not allowed",
+		expectedResult:     `{"code":"CONCEPT_TYPE_NOT_ALLOWED","message":"concept type not allowed: skos:Concept","uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","type":"skos:Concept"}`,
 	}
 	transform_duplicateFactsetIdsError := testStruct{
 		scenarioName:       "transform_duplicateFactsetIdsError",
@@ -112,6 +157,20 @@ func TestTransformAndSendHandlers(t *testing.T) {
 		expectedStatusCode: 200,
 		expectedResult:     `{"authority":"Smartlogic","uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","concordances":[{"authority":"TME","authorityValue":"AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789","uuid":"e9f4525a-401f-3b23-a68e-e48f314cdce6"},{"authority":"TME","authorityValue":"ZyXwVuTsRqPoNmLkJiHgFeDcBa-0987654321","uuid":"83f63c7e-1641-3c7b-81e4-378ae3c6c2ad"},{"authority":"TME","authorityValue":"abcdefghijklmnopqrstuvwxyz-0123456789","uuid":"e4bc4ac2-0637-3a27-86b1-9589fca6bf2c"},{"authority":"FACTSET","authorityValue":"000D63-E","uuid":"8d3aba95-02d9-3802-afc0-b99bb9b1139e"},{"authority":"FACTSET","authorityValue":"023456-E","uuid":"3bc0ab41-c01f-3a0b-aa78-c76438080b52"},{"authority":"FACTSET","authorityValue":"023411-E","uuid":"f777c5af-e0b2-34dc-9102-e346ca2d27aa"}]}`,
 	}
+	transform_convertsMultipleConceptsAndReturnsPayload := testStruct{
+		scenarioName:       "transform_convertsMultipleConceptsAndReturnsPayload",
+		filePath:           "../resources/multipleConcepts.json",
+		endpoint:           "/transform",
+		expectedStatusCode: 200,
+		expectedResult:     `[{"uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","status":200,"concordance":{"authority":"Smartlogic","uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","concordances":[{"authority":"TME","authorityValue":"AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789","uuid":"e9f4525a-401f-3b23-a68e-e48f314cdce6"}]}},{"uuid":"95f00e25-9a5f-45ec-8ad8-5607d021c74b","status":200,"concordance":{"authority":"Smartlogic","uuid":"95f00e25-9a5f-45ec-8ad8-5607d021c74b","concordances":[{"authority":"FACTSET","authorityValue":"000D63-E","uuid":"8d3aba95-02d9-3802-afc0-b99bb9b1139e"}]}}]`,
+	}
+	send_convertsAndForwardsMultipleConcepts := testStruct{
+		scenarioName:       "send_convertsAndForwardsMultipleConcepts",
+		filePath:           "../resources/multipleConcepts.json",
+		endpoint:           "/transform/send",
+		expectedStatusCode: 200,
+		expectedResult:     `[{"uuid":"20db1bd6-59f9-4404-adb5-3165a448f8b0","status":200,"message":"Concordance record forwarded to writer"},{"uuid":"95f00e25-9a5f-45ec-8ad8-5607d021c74b","status":200,"message":"Concordance record forwarded to writer"}]`,
+	}
 	send_convertsAndForwardsPayloadWithConcordance := testStruct{
 		scenarioName:       "send_convertsAndForwardsPayloadWithConcordance",
 		filePath:           "../resources/multipleTmeAndFactsetIds.json",
@@ -122,6 +181,8 @@ func TestTransformAndSendHandlers(t *testing.T) {
 
 	testScenarios := []testStruct{
 		transform_unprocessibleEntityError,
+		transform_multipleConceptsError,
+		transform_convertsMultipleConceptsAndReturnsPayload,
 		transform_convertingToConcordedJsonError,
 		transform_duplicateTmeIdsError,
 		transform_duplicateFactsetIdsError,
@@ -129,6 +190,8 @@ func TestTransformAndSendHandlers(t *testing.T) {
 		transform_convertsFactsetsAndReturnsPayload,
 		transform_convertsTmeAndFactsetsAndReturnsPayload,
 		send_unprocessibleEntityError,
+		send_multipleConceptsError,
+		send_convertsAndForwardsMultipleConcepts,
 		send_convertingToConcordedJsonError,
 		send_convertsAndForwardsPayloadWithConcordance,
 		send_convertsAndFailsForwardToRw,
@@ -145,11 +208,35 @@ func TestTransformAndSendHandlers(t *testing.T) {
 
 }
 
+func TestTransformHandlerValidationModes(t *testing.T) {
+	r := mux.NewRouter()
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockHttpClient{}), mockConsumer{}, nil)
+	h.RegisterHandlers(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("POST", "/transform?validation=all", readFile(t, "../resources/multipleInvalidIds.json")))
+	assert.Equal(t, 400, rec.Code, "Unexpected status code")
+	var resp validationErrorsResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.True(t, strings.HasPrefix(resp.Message, "4 validation errors: "), "Unexpected message "+resp.Message)
+	codes := []ValidationCode{}
+	for _, err := range resp.Errors {
+		codes = append(codes, err.Code)
+	}
+	assert.Equal(t, []ValidationCode{SELF_CONCORDANCE, INVALID_IDENTIFIER, DUPLICATE_IDENTIFIER, INVALID_IDENTIFIER}, codes)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("POST", "/transform", readFile(t, "../resources/multipleInvalidIds.json")))
+	assert.Equal(t, 400, rec.Code, "Unexpected status code")
+	assert.Contains(t, rec.Body.String(), `{"code":"SELF_CONCORDANCE",`, "Request had unexpected result")
+	assert.NotContains(t, rec.Body.String(), `"errors"`, "Request had unexpected result")
+}
+
 func TestSendHandlerSuccessfulDelete(t *testing.T) {
 	r := mux.NewRouter()
 	mockClient := mockHttpClient{resp: "", statusCode: 404}
 	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
-	h := NewHandler(defaultTransformer, mockConsumer{})
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
 	h.RegisterHandlers(r)
 
 	rec := httptest.NewRecorder()
@@ -163,7 +250,7 @@ func TestSendHandlerRecordNotFound(t *testing.T) {
 	r := mux.NewRouter()
 	mockClient := mockHttpClient{resp: "", statusCode: 204}
 	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
-	h := NewHandler(defaultTransformer, mockConsumer{})
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
 	h.RegisterHandlers(r)
 
 	rec := httptest.NewRecorder()
@@ -177,7 +264,7 @@ func TestSendHandlerUnavailableWriter(t *testing.T) {
 	r := mux.NewRouter()
 	mockClient := mockHttpClient{resp: "", statusCode: 503}
 	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
-	h := NewHandler(defaultTransformer, mockConsumer{})
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
 	h.RegisterHandlers(r)
 
 	rec := httptest.NewRecorder()
@@ -187,11 +274,24 @@ func TestSendHandlerUnavailableWriter(t *testing.T) {
 	assert.Contains(t, rec.Body.String(), "Delete request to writer returned unexpected status: 503", "Request had unexpected result")
 }
 
+func TestSendHandlerWriterTimesOut(t *testing.T) {
+	r := mux.NewRouter()
+	mockClient := mockHttpClient{err: context.DeadlineExceeded}
+	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
+	h.RegisterHandlers(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("POST", "/transform/send", readFile(t, "../resources/noTmeIds.json")))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code, "Unexpected status code")
+	assert.JSONEq(t, `{"message": "context deadline exceeded"}`, rec.Body.String(), "Request had unexpected result")
+}
+
 func TestSendHandlerWriteReturnsError(t *testing.T) {
 	r := mux.NewRouter()
 	mockClient := mockHttpClient{resp: "", statusCode: 503, err: errors.New("Delete request to writer returned unexpected status: 503")}
 	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
-	h := NewHandler(defaultTransformer, mockConsumer{})
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
 	h.RegisterHandlers(r)
 
 	rec := httptest.NewRecorder()
@@ -206,6 +306,142 @@ func (c mockHttpClient) Do(req *http.Request) (resp *http.Response, err error) {
 	return &http.Response{Body: cb, StatusCode: c.statusCode}, c.err
 }
 
+// recordingHttpClient records the requests sent to the writers and answers them in turn with statusCodes and errs, repeating the
+// last status code once they run out and 200 when there are none, unless respond is set to answer them instead.
+// It keeps the response bodies it hands out so that tests can check that every one of them was closed.
+type recordingHttpClient struct {
+	sync.Mutex
+	statusCodes []int
+	errs        []error
+	respond     func(req *http.Request) (int, error)
+	requests    []recordedRequest
+	bodies      []*countingBody
+}
+
+type recordedRequest struct {
+	method string
+	url    *url.URL
+	header http.Header
+}
+
+func (c *recordingHttpClient) Do(req *http.Request) (*http.Response, error) {
+	c.Lock()
+	call := len(c.requests)
+	c.requests = append(c.requests, recordedRequest{method: req.Method, url: req.URL, header: req.Header.Clone()})
+	c.Unlock()
+	if req.Body != nil {
+		ioutil.ReadAll(req.Body)
+	}
+
+	statusCode, err := c.answer(call, req)
+	if err != nil {
+		return nil, err
+	}
+	body := &countingBody{Reader: bytes.NewReader(nil)}
+	c.Lock()
+	c.bodies = append(c.bodies, body)
+	c.Unlock()
+	return &http.Response{Body: body, StatusCode: statusCode}, nil
+}
+
+func (c *recordingHttpClient) answer(call int, req *http.Request) (int, error) {
+	if c.respond != nil {
+		return c.respond(req)
+	}
+	if call < len(c.errs) && c.errs[call] != nil {
+		return 0, c.errs[call]
+	}
+	switch {
+	case call < len(c.statusCodes):
+		return c.statusCodes[call], nil
+	case len(c.statusCodes) > 0:
+		return c.statusCodes[len(c.statusCodes)-1], nil
+	}
+	return http.StatusOK, nil
+}
+
+func (c *recordingHttpClient) calls() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.requests)
+}
+
+// recorded returns the method and url of every request, in the order they were sent
+func (c *recordingHttpClient) recorded() []string {
+	c.Lock()
+	defer c.Unlock()
+	var requests []string
+	for _, req := range c.requests {
+		requests = append(requests, req.method+" "+req.url.String())
+	}
+	return requests
+}
+
+// methods returns the methods of the requests sent for the concept, in the order they were sent
+func (c *recordingHttpClient) methods(uuid string) []string {
+	c.Lock()
+	defer c.Unlock()
+	var methods []string
+	for _, req := range c.requests {
+		if path.Base(req.url.Path) == uuid {
+			methods = append(methods, req.method)
+		}
+	}
+	return methods
+}
+
+func (c *recordingHttpClient) headers() []http.Header {
+	c.Lock()
+	defer c.Unlock()
+	var headers []http.Header
+	for _, req := range c.requests {
+		headers = append(headers, req.header)
+	}
+	return headers
+}
+
+// openBodies returns how many of the response bodies handed out have not been closed
+func (c *recordingHttpClient) openBodies() int {
+	c.Lock()
+	defer c.Unlock()
+	open := 0
+	for _, body := range c.bodies {
+		if atomic.LoadInt32(&body.closed) == 0 {
+			open++
+		}
+	}
+	return open
+}
+
+// countingBody is a response body that counts how many times it was closed
+type countingBody struct {
+	io.Reader
+	closed int32
+}
+
+func (b *countingBody) Close() error {
+	atomic.AddInt32(&b.closed, 1)
+	return nil
+}
+
+type mockProducer struct {
+	messages []kafka.FTMessage
+	err      error
+}
+
+func (mp *mockProducer) SendMessage(message kafka.FTMessage) error {
+	mp.messages = append(mp.messages, message)
+	return mp.err
+}
+
+func (mp *mockProducer) ConnectivityCheck() error {
+	return mp.err
+}
+
+func (mp *mockProducer) Shutdown() {
+	return
+}
+
 type mockConsumer struct {
 	err error
 }
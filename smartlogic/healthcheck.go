@@ -1,6 +1,7 @@
 package smartlogic
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	serviceStatus "github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rcrowley/go-metrics"
 	log "github.com/sirupsen/logrus"
 )
@@ -29,6 +31,18 @@ func (h *SmartlogicConcordanceTransformerHandler) RegisterAdminHandlers(router *
 	monitoringRouter = httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry, monitoringRouter)
 
 	var checks = []fthealth.Check{h.concordanceRwNeo4jHealthCheck(), h.kafkaHealthCheck()}
+	if sink, ok := h.transformer.sink.(*kafkaSink); ok {
+		checks[0] = h.concordanceTopicHealthCheck(sink)
+	}
+	for _, writer := range h.transformer.writers {
+		checks = append(checks, h.writerHealthCheck(writer))
+	}
+	if h.deadLetterProducer != nil {
+		checks = append(checks, h.deadLetterHealthCheck())
+	}
+	if writer, ok := h.transformer.audit.kafkaWriter(); ok {
+		checks = append(checks, h.auditTopicHealthCheck(writer))
+	}
 
 	timedHC := fthealth.TimedHealthCheck{
 		HealthCheck: fthealth.HealthCheck{
@@ -40,6 +54,14 @@ func (h *SmartlogicConcordanceTransformerHandler) RegisterAdminHandlers(router *
 		Timeout: 10 * time.Second,
 	}
 
+	if h.transformer.dryRun != nil {
+		router.Path("/__dry-run").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(h.DryRunHandler)})
+	}
+	if h.transformer.audit != nil {
+		router.Path("/__audit/{uuid}").Handler(h.requireRole(RoleRead, handlers.MethodHandler{"GET": http.HandlerFunc(h.AuditHandler)}))
+	}
+
+	router.Path("/metrics").Handler(handlers.MethodHandler{"GET": promhttp.Handler()})
 	router.Path("/__health").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(fthealth.Handler(&timedHC))})
 	gtgHandler := serviceStatus.NewGoodToGoHandler(gtg.StatusChecker(h.gtg))
 	router.Path("/__gtg").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(gtgHandler)})
@@ -49,18 +71,35 @@ func (h *SmartlogicConcordanceTransformerHandler) RegisterAdminHandlers(router *
 }
 
 func (h *SmartlogicConcordanceTransformerHandler) gtg() gtg.Status {
+	if h.drain.isDraining() {
+		return gtg.Status{GoodToGo: false, Message: "Service is shutting down"}
+	}
+
 	kafkaQueueCheck := func() gtg.Status {
 		return gtgCheck(h.checkKafkaConnectivity)
 	}
 
 	conceptsRwS3Check := func() gtg.Status {
+		if sink, ok := h.transformer.sink.(*kafkaSink); ok {
+			return gtgCheck(sink.checkConnectivity)
+		}
 		return gtgCheck(h.checkConcordanceRwConnectivity)
 	}
 
-	return gtg.FailFastParallelCheck([]gtg.StatusChecker{
+	checkers := []gtg.StatusChecker{
 		kafkaQueueCheck,
 		conceptsRwS3Check,
-	})()
+	}
+	for _, writer := range h.transformer.writers {
+		if writer.Policy != WriterRequired {
+			continue
+		}
+		writer := writer
+		checkers = append(checkers, func() gtg.Status {
+			return gtgCheck(func() (string, error) { return h.checkWriterConnectivity(writer) })
+		})
+	}
+	return gtg.FailFastParallelCheck(checkers)()
 }
 
 func gtgCheck(handler func() (string, error)) gtg.Status {
@@ -81,20 +120,63 @@ func (h *SmartlogicConcordanceTransformerHandler) kafkaHealthCheck() fthealth.Ch
 	}
 }
 
+func (h *SmartlogicConcordanceTransformerHandler) deadLetterHealthCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Smartlogic messages that fail transformation will not be kept for inspection and replay",
+		Name:             "Check connectivity to the dead-letter Kafka topic",
+		PanicGuide:       deweyURL,
+		Severity:         3,
+		TechnicalSummary: `Check that kafka is healthy in this cluster and that the dead-letter topic exists; if so restart this service`,
+		Checker:          h.checkDeadLetterConnectivity,
+	}
+}
+
+func (h *SmartlogicConcordanceTransformerHandler) concordanceTopicHealthCheck(sink *kafkaSink) fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   businessImpact,
+		Name:             "Check connectivity to the concordance Kafka topic",
+		PanicGuide:       deweyURL,
+		Severity:         3,
+		TechnicalSummary: `Check that kafka is healthy in this cluster and that the concordance topic exists; if so restart this service`,
+		Checker:          sink.checkConnectivity,
+	}
+}
+
+func (h *SmartlogicConcordanceTransformerHandler) auditTopicHealthCheck(writer *kafkaAuditWriter) fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Writes and deletes of concordances will not be recorded in the audit log",
+		Name:             "Check connectivity to the audit Kafka topic",
+		PanicGuide:       deweyURL,
+		Severity:         3,
+		TechnicalSummary: `Check that kafka is healthy in this cluster and that the audit topic exists; if so restart this service`,
+		Checker:          writer.checkConnectivity,
+	}
+}
+
 func (h *SmartlogicConcordanceTransformerHandler) concordanceRwNeo4jHealthCheck() fthealth.Check {
 	return fthealth.Check{
 		BusinessImpact:   businessImpact,
 		Name:             "Check connectivity to concordance reader/writer ",
 		PanicGuide:       deweyURL,
 		Severity:         3,
-		TechnicalSummary: `Check health of concordances-rw-neo4j`,
+		TechnicalSummary: `Check health of concordances-rw-neo4j. Also fails while the circuit breaker to concordances-rw-neo4j is open, during which Kafka consumption is paused`,
 		Checker:          h.checkConcordanceRwConnectivity,
 	}
 }
 
 func (h *SmartlogicConcordanceTransformerHandler) checkConcordanceRwConnectivity() (string, error) {
+	if h.transformer.dryRun != nil {
+		return "Dry run: requests are not sent to Concordances Rw Neo4j", nil
+	}
+	if state := h.transformer.breaker.currentState(); state != breakerClosed {
+		clientError := fmt.Sprintf("Circuit breaker to concordances-rw-neo4j is %s; Kafka consumption is paused", state)
+		log.Error(clientError)
+		return clientError, errors.New("Circuit breaker to concordances-rw-neo4j is " + state.String())
+	}
 	urlToCheck := h.transformer.writerAddress + "__gtg"
-	request, err := http.NewRequest("GET", urlToCheck, nil)
+	ctx, cancel := h.transformer.writerCallContext(context.Background())
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "GET", urlToCheck, nil)
 	if err != nil {
 		clientError := fmt.Sprintf("Error creating request to writer %s : %v", urlToCheck, err)
 		log.WithError(err).Error(clientError)
@@ -115,6 +197,36 @@ func (h *SmartlogicConcordanceTransformerHandler) checkConcordanceRwConnectivity
 	return "Successfully connected to Concordances Rw Neo4j", nil
 }
 
+// writerHealthCheck checks an additional writer; only required writers are also part of __gtg, as a best-effort writer being down
+// does not stop concordances from being written
+func (h *SmartlogicConcordanceTransformerHandler) writerHealthCheck(writer WriterConfig) fthealth.Check {
+	check := fthealth.Check{
+		BusinessImpact:   businessImpact,
+		Name:             fmt.Sprintf("Check connectivity to the %s concordances writer %s", writer.Policy, writer.Name),
+		PanicGuide:       deweyURL,
+		Severity:         3,
+		TechnicalSummary: fmt.Sprintf(`Check health of %s at %s. Concordances fail, and are dead-lettered, while this required writer is unavailable`, writer.Name, writer.Address),
+		Checker:          func() (string, error) { return h.checkWriterConnectivity(writer) },
+	}
+	if writer.Policy == WriterBestEffort {
+		check.BusinessImpact = "Concordance updates will be missing from " + writer.Name
+		check.TechnicalSummary = fmt.Sprintf(`Check health of %s at %s. Concordances are still written to the other writers while this best-effort writer is unavailable`, writer.Name, writer.Address)
+	}
+	return check
+}
+
+func (h *SmartlogicConcordanceTransformerHandler) checkWriterConnectivity(writer WriterConfig) (string, error) {
+	if h.transformer.dryRun != nil {
+		return "Dry run: requests are not sent to " + writer.Name, nil
+	}
+	if err := h.transformer.probeWriterAt(writer.Address); err != nil {
+		clientError := fmt.Sprintf("Error calling writer %s at %s : %v", writer.Name, writer.Address, err)
+		log.WithError(err).WithField("writer", writer.Name).Error(clientError)
+		return clientError, errors.New("Unable to verify availability of " + writer.Name)
+	}
+	return "Successfully connected to " + writer.Name, nil
+}
+
 func (h *SmartlogicConcordanceTransformerHandler) checkKafkaConnectivity() (string, error) {
 	err := h.consumer.ConnectivityCheck()
 	if err != nil {
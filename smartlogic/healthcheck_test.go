@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -13,7 +14,7 @@ func TestAdminHandler_Healthy(t *testing.T) {
 	r := mux.NewRouter()
 	mockClient := mockHttpClient{resp: "", statusCode: 200}
 	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient)
-	h := NewHandler(defaultTransformer, mockConsumer{})
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
 	h.RegisterAdminHandlers(r, "appy-mcappface", "Appy-McAppface", "My first app")
 
 	type testStruct struct {
@@ -37,3 +38,15 @@ func TestAdminHandler_Healthy(t *testing.T) {
 	}
 
 }
+
+func TestAdminHandler_CircuitBreakerOpen(t *testing.T) {
+	mockClient := mockHttpClient{resp: "", statusCode: 200}
+	defaultTransformer := NewTransformerService(TOPIC, WRITER_ADDRESS, &mockClient, WithCircuitBreaker(CircuitBreakerConfig{FailureRate: 1, MinRequests: 1, WindowSize: 1, OpenDuration: time.Minute}))
+	defaultTransformer.breaker.record(false)
+	h := NewHandler(defaultTransformer, mockConsumer{}, nil)
+
+	msg, err := h.checkConcordanceRwConnectivity()
+	assert.Error(t, err)
+	assert.Contains(t, msg, "Circuit breaker to concordances-rw-neo4j is open")
+	assert.False(t, h.gtg().GoodToGo)
+}
@@ -0,0 +1,171 @@
+package smartlogic
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/Shopify/sarama"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	messageTypeHeader     = "Message-Type"
+	upsertMessageType     = "concordance-upsert"
+	deleteMessageType     = "concordance-delete"
+	concordanceOriginCode = "http://cmdb.ft.com/systems/smartlogic-concordance-transformer"
+)
+
+// ConcordanceProducer publishes to a Kafka topic under the uuid of the concept as message key, so that the updates of a concept
+// stay in order on a single partition and a compacted topic keeps only the latest of them.
+type ConcordanceProducer interface {
+	// SendMessage publishes an FT message under key
+	SendMessage(key string, message kafka.FTMessage) error
+	// SendTombstone publishes a message without a value under key, with headers as Kafka record headers
+	SendTombstone(key string, headers map[string]string) error
+	ConnectivityCheck() error
+	Shutdown()
+}
+
+// kafkaSink publishes every concordance to a Kafka topic instead of writing it to concordances-rw-neo4j. A concept with concordances is
+// published as an FT message with its UppConcordance as body; one without is published as a tombstone.
+type kafkaSink struct {
+	producer ConcordanceProducer
+	topic    string
+}
+
+// WithKafkaSink publishes concordances to the topic of producer instead of sending them to concordances-rw-neo4j
+func WithKafkaSink(producer ConcordanceProducer, topic string) TransformerOption {
+	return func(ts *TransformerService) {
+		ts.sink = &kafkaSink{producer: producer, topic: topic}
+	}
+}
+
+func (s *kafkaSink) write(ctx context.Context, uuid string, uppConcordance UppConcordance, tid string) (status, []WriterOutcome, error) {
+	ctx, span := startSpan(ctx, "publishConcordance", trace.SpanKindProducer, s.spanAttributes(uuid, tid, upsertMessageType)...)
+	body, err := json.Marshal(uppConcordance)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Bad Request: Could not unmarshall concordance json")
+		endSpan(span, err)
+		return SYNTACTICALLY_INCORRECT, nil, err
+	}
+
+	err = s.producer.SendMessage(uuid, kafka.NewFTMessage(s.headers(ctx, tid, upsertMessageType), string(body)))
+	observeSinkResult(upsertMessageType, err)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "topic": s.topic}).Error("Service Unavailable: Failed to publish concordance to Kafka")
+		endSpan(span, err)
+		return SERVICE_UNAVAILABLE, nil, err
+	}
+	endSpan(span, nil)
+	return VALID_CONCEPT, nil, nil
+}
+
+func (s *kafkaSink) delete(ctx context.Context, uuid string, tid string) (status, []WriterOutcome, error) {
+	ctx, span := startSpan(ctx, "publishConcordance", trace.SpanKindProducer, s.spanAttributes(uuid, tid, deleteMessageType)...)
+	err := s.producer.SendTombstone(uuid, s.headers(ctx, tid, deleteMessageType))
+	observeSinkResult(deleteMessageType, err)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "topic": s.topic}).Error("Service Unavailable: Failed to publish concordance tombstone to Kafka")
+		endSpan(span, err)
+		return SERVICE_UNAVAILABLE, nil, err
+	}
+	endSpan(span, nil)
+	return NO_CONTENT, nil, nil
+}
+
+// headers are the headers of upserts and tombstones alike, so that consumers tell them apart by their Message-Type alone
+func (s *kafkaSink) headers(ctx context.Context, tid string, messageType string) map[string]string {
+	headers := map[string]string{
+		"X-Request-Id":         tid,
+		"Message-Id":           uuid.NewRandom().String(),
+		messageTypeHeader:      messageType,
+		"Origin-System-Id":     concordanceOriginCode,
+		messageTimestampHeader: time.Now().UTC().Format(messageTimestampFormat),
+		"Content-Type":         "application/json",
+	}
+	traceContext.Inject(ctx, kafkaHeaderCarrier(headers))
+	return headers
+}
+
+func (s *kafkaSink) spanAttributes(uuid string, tid string, messageType string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("concept.uuid", uuid),
+		attribute.String("transaction_id", tid),
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination", s.topic),
+		attribute.String("messaging.message_type", messageType),
+	}
+}
+
+func (s *kafkaSink) checkConnectivity() (string, error) {
+	if err := s.producer.ConnectivityCheck(); err != nil {
+		log.WithError(err).Error("Error verifying open connection to the concordance topic")
+		return "Error connecting to the concordance topic", err
+	}
+	return "Successfully connected to the concordance topic", nil
+}
+
+// saramaConcordanceProducer is the ConcordanceProducer of the service. The FT Kafka client cannot set message keys, so it uses sarama,
+// whose default partitioner hashes the key.
+type saramaConcordanceProducer struct {
+	client   sarama.Client
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewConcordanceProducer connects to the Kafka brokers, given in the form host1:9092,host2:9092, to publish to topic
+func NewConcordanceProducer(brokers string, topic string) (ConcordanceProducer, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_11_0_0 // record headers, which tombstones carry their headers in
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	client, err := sarama.NewClient(strings.Split(brokers, ","), config)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &saramaConcordanceProducer{client: client, producer: producer, topic: topic}, nil
+}
+
+func (p *saramaConcordanceProducer) SendMessage(key string, message kafka.FTMessage) error {
+	_, _, err := p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.StringEncoder(message.Build()),
+	})
+	return err
+}
+
+func (p *saramaConcordanceProducer) SendTombstone(key string, headers map[string]string) error {
+	recordHeaders := make([]sarama.RecordHeader, 0, len(headers))
+	for k, v := range headers {
+		recordHeaders = append(recordHeaders, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	_, _, err := p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   p.topic,
+		Key:     sarama.StringEncoder(key),
+		Headers: recordHeaders,
+	})
+	return err
+}
+
+func (p *saramaConcordanceProducer) ConnectivityCheck() error {
+	return p.client.RefreshMetadata(p.topic)
+}
+
+func (p *saramaConcordanceProducer) Shutdown() {
+	if err := p.producer.Close(); err != nil {
+		log.WithError(err).Warn("Error closing the concordance topic producer")
+	}
+	p.client.Close()
+}
@@ -0,0 +1,99 @@
+package smartlogic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+type keyedMessage struct {
+	key       string
+	message   kafka.FTMessage
+	tombstone bool
+}
+
+type mockConcordanceProducer struct {
+	messages []keyedMessage
+	err      error
+}
+
+func (p *mockConcordanceProducer) SendMessage(key string, message kafka.FTMessage) error {
+	p.messages = append(p.messages, keyedMessage{key: key, message: message})
+	return p.err
+}
+
+func (p *mockConcordanceProducer) SendTombstone(key string, headers map[string]string) error {
+	p.messages = append(p.messages, keyedMessage{key: key, message: kafka.FTMessage{Headers: headers}, tombstone: true})
+	return p.err
+}
+
+func (p *mockConcordanceProducer) ConnectivityCheck() error {
+	return p.err
+}
+
+func (p *mockConcordanceProducer) Shutdown() {}
+
+func TestKafkaSink(t *testing.T) {
+	uuid := "20db1bd6-59f9-4404-adb5-3165a448f8b0"
+	withConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`
+	noConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"]}]}`
+
+	type testStruct struct {
+		scenarioName        string
+		body                string
+		producerErr         error
+		expectedStatus      status
+		expectedMessageType string
+		expectedTombstone   bool
+	}
+
+	scenarios := []testStruct{
+		{scenarioName: "upsertPublished", body: withConcordance, expectedStatus: VALID_CONCEPT, expectedMessageType: upsertMessageType},
+		{scenarioName: "tombstonePublished", body: noConcordance, expectedStatus: VALID_CONCEPT, expectedMessageType: deleteMessageType, expectedTombstone: true},
+		{scenarioName: "publishFails", body: withConcordance, producerErr: errors.New("kafka unavailable"), expectedStatus: SERVICE_UNAVAILABLE, expectedMessageType: upsertMessageType},
+	}
+
+	expectedHeaders := []string{"X-Request-Id", "Message-Id", messageTypeHeader, "Origin-System-Id", messageTimestampHeader, "Content-Type"}
+
+	for _, scenario := range scenarios {
+		producer := &mockConcordanceProducer{err: scenario.producerErr}
+		client := &recordingHttpClient{}
+		ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client, WithKafkaSink(producer, "Concordances"))
+
+		updateStatus, results, err := ts.handleConcordanceEvent(context.Background(), scenario.body, "tid_sink")
+		assert.Equal(t, scenario.expectedStatus, updateStatus, "Scenario: "+scenario.scenarioName+" failed")
+		assert.Equal(t, scenario.producerErr, err, "Scenario: "+scenario.scenarioName+" failed")
+		assert.Zero(t, client.calls(), "Scenario: "+scenario.scenarioName+" failed")
+
+		assert.Len(t, producer.messages, 1, "Scenario: "+scenario.scenarioName+" failed")
+		published := producer.messages[0]
+		assert.Equal(t, uuid, published.key, "Scenario: "+scenario.scenarioName+" failed")
+		assert.Equal(t, scenario.expectedTombstone, published.tombstone, "Scenario: "+scenario.scenarioName+" failed")
+		assert.Equal(t, scenario.expectedMessageType, published.message.Headers[messageTypeHeader], "Scenario: "+scenario.scenarioName+" failed")
+		assert.Equal(t, "tid_sink", published.message.Headers["X-Request-Id"], "Scenario: "+scenario.scenarioName+" failed")
+		assert.NotEmpty(t, published.message.Headers["Message-Id"], "Scenario: "+scenario.scenarioName+" failed")
+		var headers []string
+		for header := range published.message.Headers {
+			headers = append(headers, header)
+		}
+		assert.ElementsMatch(t, expectedHeaders, headers, "Scenario: "+scenario.scenarioName+" failed: upserts and tombstones should carry the same headers")
+		if !scenario.expectedTombstone {
+			expected, _ := json.Marshal(results[0].UppConcordance)
+			assert.JSONEq(t, string(expected), published.message.Body, "Scenario: "+scenario.scenarioName+" failed")
+		}
+	}
+}
+
+func TestKafkaSinkGoodToGo(t *testing.T) {
+	producer := &mockConcordanceProducer{}
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, &mockHttpClient{statusCode: 503}, WithKafkaSink(producer, "Concordances")), mockConsumer{}, nil)
+	assert.True(t, h.gtg().GoodToGo, "The writer should not be checked when concordances are published to Kafka")
+
+	producer.err = errors.New("kafka unavailable")
+	assert.False(t, h.gtg().GoodToGo)
+	assert.Equal(t, "kafka unavailable", h.gtg().Message)
+}
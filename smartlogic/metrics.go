@@ -0,0 +1,137 @@
+package smartlogic
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "smartlogic_concordance_transformer"
+
+var (
+	kafkaMessagesConsumed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kafka_messages_consumed_total",
+		Help:      "Smartlogic messages consumed from Kafka.",
+	})
+	transformOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "transform_outcomes_total",
+		Help:      "Smartlogic concepts transformed, by outcome. Payloads without a @graph are counted once.",
+	}, []string{"status"})
+	concordancesEmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "concordances_emitted_total",
+		Help:      "Concorded ids produced by successfully transformed concepts, by authority.",
+	}, []string{"authority"})
+	writerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "writer_requests_total",
+		Help:      "Requests to concordances-rw-neo4j and the additional writers after retries, by method and response status; status is \"timeout\" when the writer did not answer in time and \"error\" when no response was received otherwise.",
+	}, []string{"method", "status"})
+	writerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "writer_request_duration_seconds",
+		Help:      "Latency of every call to concordances-rw-neo4j, including each retry, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	writesSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "writer_requests_skipped_total",
+		Help:      "Requests to concordances-rw-neo4j not made because the concordance was unchanged since the last write.",
+	})
+	writesCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "writer_requests_coalesced_total",
+		Help:      "Concordance updates not sent to concordances-rw-neo4j because a later update for the concept arrived within the debounce window.",
+	})
+	concordancesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "concordance_messages_published_total",
+		Help:      "Concordances published to the concordance Kafka topic, by message type (concordance-upsert or concordance-delete) and status (published or error).",
+	}, []string{"message_type", "status"})
+	writerOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "writer_outcomes_total",
+		Help:      "Concordances fanned out to several writers, by writer, policy (required or best-effort) and outcome status.",
+	}, []string{"writer", "policy", "status"})
+	writerAuthReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "writer_auth_reloads_total",
+		Help:      "Reloads of the writer credentials after their files changed, by status (reloaded or error).",
+	}, []string{"status"})
+	authRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_auth_rejections_total",
+		Help:      "Requests to endpoints requiring a role that were rejected, by path and response status (401 unauthenticated or 403 forbidden).",
+	}, []string{"path", "status"})
+	auditRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_records_total",
+		Help:      "Records of concordance writes and deletes appended to the audit log, by status (written or error).",
+	}, []string{"status"})
+	kafkaMessagesQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "kafka_messages_queued",
+		Help:      "Smartlogic messages consumed from Kafka that are waiting for a worker, including those held up by a full queue.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(kafkaMessagesConsumed, transformOutcomes, concordancesEmitted, writerRequests, writerRequestDuration, writesSkipped, writesCoalesced, concordancesPublished, writerOutcomes, writerAuthReloads, authRejections, auditRecords, kafkaMessagesQueued)
+}
+
+func observeTransform(s status, uppConcordance UppConcordance) {
+	transformOutcomes.WithLabelValues(s.String()).Inc()
+	for _, concordedId := range uppConcordance.ConcordedIds {
+		concordancesEmitted.WithLabelValues(concordedId.Authority).Inc()
+	}
+}
+
+func observeWriterCall(method string, start time.Time) {
+	writerRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func observeSinkResult(messageType string, err error) {
+	label := "published"
+	if err != nil {
+		label = "error"
+	}
+	concordancesPublished.WithLabelValues(messageType, label).Inc()
+}
+
+func observeWriterOutcome(outcome WriterOutcome) {
+	writerOutcomes.WithLabelValues(outcome.Writer, outcome.Policy.String(), outcome.Status.String()).Inc()
+}
+
+func observeWriterAuthReload(err error) {
+	label := "reloaded"
+	if err != nil {
+		label = "error"
+	}
+	writerAuthReloads.WithLabelValues(label).Inc()
+}
+
+func observeAuthRejection(path string, statusCode int) {
+	authRejections.WithLabelValues(path, strconv.Itoa(statusCode)).Inc()
+}
+
+func observeAuditRecord(err error) {
+	label := "written"
+	if err != nil {
+		label = "error"
+	}
+	auditRecords.WithLabelValues(label).Inc()
+}
+
+func observeWriterResult(method string, statusCode int, err error) {
+	label := "error"
+	switch {
+	case err == nil:
+		label = strconv.Itoa(statusCode)
+	case isTimeout(err):
+		label = "timeout"
+	}
+	writerRequests.WithLabelValues(method, label).Inc()
+}
@@ -0,0 +1,51 @@
+package smartlogic
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformAndWriterMetrics(t *testing.T) {
+	consumed := testutil.ToFloat64(kafkaMessagesConsumed)
+	valid := testutil.ToFloat64(transformOutcomes.WithLabelValues("VALID_CONCEPT"))
+	invalid := testutil.ToFloat64(transformOutcomes.WithLabelValues("SYNTACTICALLY_INCORRECT"))
+	tme := testutil.ToFloat64(concordancesEmitted.WithLabelValues(CONCORDANCE_AUTHORITY_TME))
+	factset := testutil.ToFloat64(concordancesEmitted.WithLabelValues(CONCORDANCE_AUTHORITY_FACTSET))
+	put201 := testutil.ToFloat64(writerRequests.WithLabelValues("PUT", "201"))
+	deleteErr := testutil.ToFloat64(writerRequests.WithLabelValues("DELETE", "error"))
+
+	client := &recordingHttpClient{statusCodes: []int{201, 201, 0}, errs: []error{nil, nil, errors.New("connection refused")}}
+	h := NewHandler(NewTransformerService(TOPIC, writerUrl, client), mockConsumer{}, nil)
+
+	h.ProcessKafkaMessage(kafka.FTMessage{Body: readFile(t, "../resources/multipleConcepts.json")})
+	h.ProcessKafkaMessage(kafka.FTMessage{Body: readFile(t, "../resources/invalidTmeId.json")})
+	h.ProcessKafkaMessage(kafka.FTMessage{Body: readFile(t, "../resources/noTmeIds.json")})
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(kafkaMessagesConsumed)-consumed)
+	assert.Equal(t, float64(3), testutil.ToFloat64(transformOutcomes.WithLabelValues("VALID_CONCEPT"))-valid)
+	assert.Equal(t, float64(1), testutil.ToFloat64(transformOutcomes.WithLabelValues("SYNTACTICALLY_INCORRECT"))-invalid)
+	assert.Equal(t, float64(1), testutil.ToFloat64(concordancesEmitted.WithLabelValues(CONCORDANCE_AUTHORITY_TME))-tme)
+	assert.Equal(t, float64(1), testutil.ToFloat64(concordancesEmitted.WithLabelValues(CONCORDANCE_AUTHORITY_FACTSET))-factset)
+	assert.Equal(t, float64(2), testutil.ToFloat64(writerRequests.WithLabelValues("PUT", "201"))-put201)
+	assert.Equal(t, float64(1), testutil.ToFloat64(writerRequests.WithLabelValues("DELETE", "error"))-deleteErr)
+
+	rec := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rec, newRequest("GET", "/metrics", ""))
+	body := rec.Body.String()
+	for _, name := range []string{
+		"smartlogic_concordance_transformer_kafka_messages_consumed_total",
+		`smartlogic_concordance_transformer_transform_outcomes_total{status="VALID_CONCEPT"}`,
+		`smartlogic_concordance_transformer_concordances_emitted_total{authority="TME"}`,
+		`smartlogic_concordance_transformer_writer_requests_total{method="PUT",status="201"}`,
+		`smartlogic_concordance_transformer_writer_request_duration_seconds_bucket{method="DELETE",le="0.005"}`,
+	} {
+		assert.True(t, strings.Contains(body, name), "Metric "+name+" is not exposed")
+	}
+}
@@ -10,41 +10,19 @@ type SmartlogicConcept struct {
 }
 
 type Concept struct {
-	ID             string   `json:"@id"`
-	Types          []string `json:"@type,omitempty"`
-	currentConcept Concepter
+	ID          string   `json:"@id"`
+	Types       []string `json:"@type,omitempty"`
+	identifiers map[string][]conceptIdentifier
 }
 
-type Concepter interface {
-	TmeIdentifiers() []TmeId
-	FactsetIdentifiers() []FactsetId
-	DbpediaIdentifiers() []LocationType
-	GeonamesIdentifiers() []LocationType
-	WikidataIdentifiers() []LocationType
-}
-
-type ConceptML struct {
-	TmeIdentifiersValue      []TmeId        `json:"http://www.ft.com/ontology/managedlocation/TMEIdentifier,omitempty"`
-	FactsetIdentifiersValue  []FactsetId    `json:"http://www.ft.com/ontology/managedlocation/factsetIdentifier,omitempty"`
-	DbpediaIdentifiersValue  []LocationType `json:"http://www.ft.com/ontology/managedlocation/dbpediaId,omitempty"`
-	GeonamesIdentifiersValue []LocationType `json:"http://www.ft.com/ontology/managedlocation/geonamesId,omitempty"`
-	WikidataIdentifiersValue []LocationType `json:"http://www.ft.com/ontology/managedlocation/wikidataId,omitempty"`
-}
-
-type ConceptEditorial struct {
-	TmeIdentifiersValue      []TmeId        `json:"http://www.ft.com/ontology/TMEIdentifier,omitempty"`
-	FactsetIdentifiersValue  []FactsetId    `json:"http://www.ft.com/ontology/factsetIdentifier,omitempty"`
-	WikidataIdentifiersValue []LocationType `json:"http://www.ft.com/ontology/wikidataIdentifier,omitempty"`
-	GeonamesIdentifiersValue []LocationType `json:"http://www.ft.com/ontology/geonamesIdentifier,omitempty"`
-}
-
-type TmeId struct {
+type identifierValue struct {
 	Value string `json:"@value"`
 }
 
-type FactsetId struct {
-	Language string `json:"@language"`
-	Value    string `json:"@value"`
+// conceptIdentifier is an identifier value together with the JSON-LD predicate it was read from
+type conceptIdentifier struct {
+	Value     string
+	Predicate string
 }
 
 type UppConcordance struct {
@@ -59,97 +37,63 @@ type ConcordedId struct {
 	UUID           string `json:"uuid"`
 }
 
-type LocationType struct {
-	Type  string `json:"@type"`
-	Value string `json:"@value"`
+// ConceptResult is the outcome of transforming, and optionally forwarding, a single concept from a smartlogic @graph
+type ConceptResult struct {
+	ConceptUuid    string
+	Status         status
+	UppConcordance UppConcordance
+	Err            error
+	// Writers holds the outcome of every writer when concordances are fanned out to several writers
+	Writers []WriterOutcome
 }
 
 func (c *Concept) UnmarshalJSON(data []byte) error {
-	aux := &struct {
+	var predicates map[string]json.RawMessage
+	if err := json.Unmarshal(data, &predicates); err != nil {
+		return err
+	}
+
+	aux := struct {
 		ID    string   `json:"@id"`
 		Types []string `json:"@type,omitempty"`
-		*ConceptML
-		*ConceptEditorial
 	}{}
-
 	if err := json.Unmarshal(data, &aux); err != nil {
 		return err
 	}
+
+	family := EditorialConcept
 	if strings.Contains(aux.ID, "managedlocation") {
-		if aux.ConceptML == nil {
-			c.currentConcept = &ConceptML{}
-		} else {
-			c.currentConcept = aux.ConceptML
-		}
-	} else {
-		if aux.ConceptEditorial == nil {
-			c.currentConcept = &ConceptEditorial{}
-		} else {
-			c.currentConcept = aux.ConceptEditorial
+		family = ManagedLocationConcept
+	}
+
+	identifiers := map[string][]conceptIdentifier{}
+	for _, authority := range authorities.all() {
+		for _, predicate := range authority.Predicates[family] {
+			raw, found := predicates[predicate]
+			if !found {
+				continue
+			}
+			var values []identifierValue
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return err
+			}
+			for _, v := range values {
+				identifiers[authority.Name] = append(identifiers[authority.Name], conceptIdentifier{Value: v.Value, Predicate: predicate})
+			}
 		}
 	}
 
 	c.ID = aux.ID
 	c.Types = aux.Types
+	c.identifiers = identifiers
 	return nil
 }
 
-func (c Concept) TmeIdentifiers() []TmeId {
-	return c.currentConcept.TmeIdentifiers()
-}
-
-func (c Concept) FactsetIdentifiers() []FactsetId {
-	return c.currentConcept.FactsetIdentifiers()
-}
-
-func (c *Concept) DbpediaIdentifiers() []LocationType {
-	return c.currentConcept.DbpediaIdentifiers()
-}
-
-func (c *Concept) GeonamesIdentifiers() []LocationType {
-	return c.currentConcept.GeonamesIdentifiers()
-}
-
-func (c *Concept) WikidataIdentifiers() []LocationType {
-	return c.currentConcept.WikidataIdentifiers()
-}
-
-func (c ConceptEditorial) DbpediaIdentifiers() []LocationType {
-	return nil
-}
-
-func (c ConceptEditorial) GeonamesIdentifiers() []LocationType {
-	return c.GeonamesIdentifiersValue
-}
-
-func (c ConceptEditorial) WikidataIdentifiers() []LocationType {
-	return c.WikidataIdentifiersValue
-}
-
-func (c ConceptEditorial) TmeIdentifiers() []TmeId {
-	return c.TmeIdentifiersValue
-}
-
-func (c ConceptEditorial) FactsetIdentifiers() []FactsetId {
-	return c.FactsetIdentifiersValue
-}
-
-func (c ConceptML) TmeIdentifiers() []TmeId {
-	return c.TmeIdentifiersValue
-}
-
-func (c ConceptML) FactsetIdentifiers() []FactsetId {
-	return c.FactsetIdentifiersValue
-}
-
-func (c ConceptML) DbpediaIdentifiers() []LocationType {
-	return c.DbpediaIdentifiersValue
-}
-
-func (c ConceptML) GeonamesIdentifiers() []LocationType {
-	return c.GeonamesIdentifiersValue
-}
-
-func (c ConceptML) WikidataIdentifiers() []LocationType {
-	return c.WikidataIdentifiersValue
+// Identifiers returns the values of all the predicates the given authority declares for the family of this concept
+func (c Concept) Identifiers(authority string) []string {
+	values := make([]string, 0, len(c.identifiers[authority]))
+	for _, identifier := range c.identifiers[authority] {
+		values = append(values, identifier.Value)
+	}
+	return values
 }
@@ -0,0 +1,304 @@
+package smartlogic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const replayQueueSize = 10
+
+// replayExtensions are the files read from a directory given to Replay; files named explicitly are read whatever their extension
+var replayExtensions = map[string]bool{".json": true, ".jsonld": true, ".ndjson": true}
+
+// ReplayConfig controls how Replay re-drives Smartlogic exports through the transformer
+type ReplayConfig struct {
+	// Concurrency is the number of concepts written in parallel; the documents of a concept are always written in the order they were read
+	Concurrency int
+	// RateLimit caps the requests made to the writer per second; 0 leaves them unlimited
+	RateLimit float64
+}
+
+// ReplaySource is a file, or stdin, holding Smartlogic documents
+type ReplaySource struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// ReplayFailure identifies a document, or a concept of it, that could not be replayed
+type ReplayFailure struct {
+	Source      string `json:"source"`
+	Document    int    `json:"document"`
+	ConceptUuid string `json:"uuid,omitempty"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+}
+
+// ReplaySummary counts the outcome of every concept replayed. Concepts whose concordance was unchanged, that had nothing to delete
+// or whose type does not have concordances are skipped.
+type ReplaySummary struct {
+	Documents int             `json:"documents"`
+	Written   int             `json:"written"`
+	Deleted   int             `json:"deleted"`
+	Skipped   int             `json:"skipped"`
+	Failed    int             `json:"failed"`
+	Failures  []ReplayFailure `json:"failures,omitempty"`
+	lock      sync.Mutex
+}
+
+// ReplaySources lists the sources for the given paths: "-" is stdin, a directory stands for the JSON files below it, in name order,
+// and any other path is a file. Without paths, documents are read from stdin.
+func ReplaySources(paths []string, stdin io.Reader) ([]ReplaySource, error) {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var sources []ReplaySource
+	for _, path := range paths {
+		if path == "-" {
+			sources = append(sources, ReplaySource{Name: "stdin", Open: func() (io.ReadCloser, error) { return ioutil.NopCloser(stdin), nil }})
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			sources = append(sources, fileReplaySource(path))
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && replayExtensions[strings.ToLower(filepath.Ext(file))] {
+				sources = append(sources, fileReplaySource(file))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
+func fileReplaySource(path string) ReplaySource {
+	return ReplaySource{Name: path, Open: func() (io.ReadCloser, error) { return os.Open(path) }}
+}
+
+// Replay reads the Smartlogic documents of every source in turn, transforms their concepts and sends their concordances to the writer,
+// as if the documents had been consumed from Kafka. Once ctx is done no further documents are read and the writes in flight are aborted.
+func (ts *TransformerService) Replay(ctx context.Context, sources []ReplaySource, config ReplayConfig) *ReplaySummary {
+	summary := &ReplaySummary{}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	workers := newConceptWorkerPool(config.Concurrency, replayQueueSize, nil)
+	limiter := newRateLimiter(config.RateLimit)
+	defer limiter.stop()
+
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			break
+		}
+		ts.replaySource(ctx, source, workers, limiter, summary, &wg)
+	}
+	wg.Wait()
+
+	sort.SliceStable(summary.Failures, func(i, j int) bool {
+		a, b := summary.Failures[i], summary.Failures[j]
+		return a.Source < b.Source || a.Source == b.Source && a.Document < b.Document
+	})
+	return summary
+}
+
+func (ts *TransformerService) replaySource(ctx context.Context, source ReplaySource, workers *conceptWorkerPool, limiter *rateLimiter, summary *ReplaySummary, wg *sync.WaitGroup) {
+	r, err := source.Open()
+	if err != nil {
+		summary.fail(source.Name, 0, "", INTERNAL_ERROR, err)
+		return
+	}
+	defer r.Close()
+	decoder, err := newDocumentDecoder(r)
+	if err != nil {
+		summary.fail(source.Name, 0, "", SYNTACTICALLY_INCORRECT, err)
+		return
+	}
+
+	for document, fatal := 0, false; !fatal && ctx.Err() == nil; document++ {
+		var smartLogicConcept = SmartlogicConcept{}
+		var err error
+		fatal, err = decoder.next(&smartLogicConcept)
+		if err == io.EOF {
+			return
+		}
+		summary.read()
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"source": source.Name, "document": document}).Error("Error whilst reading replayed document")
+			summary.fail(source.Name, document, "", SYNTACTICALLY_INCORRECT, err)
+			continue
+		}
+
+		ids := make([]string, len(smartLogicConcept.Concepts))
+		for i, concept := range smartLogicConcept.Concepts {
+			ids[i] = concept.ID
+		}
+		workerIDs, members := workers.assign(ids)
+		if len(workerIDs) == 0 {
+			workerIDs, members = []int{workers.partition("")}, map[int][]int{}
+		}
+		// The concepts of a document are split between their workers, so that every concept is written in the order it is read
+		for _, worker := range workerIDs {
+			part := SmartlogicConcept{Concepts: smartLogicConcept.Concepts}
+			if len(workerIDs) > 1 {
+				part.Concepts = nil
+				for _, i := range members[worker] {
+					part.Concepts = append(part.Concepts, smartLogicConcept.Concepts[i])
+				}
+			}
+			wg.Add(1)
+			name, document := source.Name, document
+			err = workers.submitTo(ctx, worker, func() {
+				defer wg.Done()
+				ts.replayDocument(ctx, name, document, part, limiter, summary)
+			})
+			if err != nil {
+				wg.Done()
+				summary.fail(source.Name, document, "", SERVICE_UNAVAILABLE, err)
+			}
+		}
+	}
+}
+
+func (ts *TransformerService) replayDocument(ctx context.Context, source string, document int, smartLogicConcept SmartlogicConcept, limiter *rateLimiter, summary *ReplaySummary) {
+	tid := transactionidutils.NewTransactionID()
+	log.WithFields(log.Fields{"transaction_id": tid, "source": source, "document": document}).Debug("Replaying document")
+	ctx = withAuditSource(ctx, AuditSource{Type: "replay", File: source, Document: document})
+	updateStatus, results, err := convertToUppConcordances(ctx, smartLogicConcept, tid, failFast)
+	if err != nil {
+		summary.fail(source, document, "", updateStatus, err)
+		return
+	}
+
+	for _, result := range results {
+		if errors.Is(result.Err, errConceptTypeNotAllowed) {
+			summary.skip()
+			continue
+		}
+		if result.Err != nil {
+			summary.fail(source, document, result.ConceptUuid, result.Status, result.Err)
+			continue
+		}
+		if err := limiter.wait(ctx); err != nil {
+			summary.fail(source, document, result.ConceptUuid, SERVICE_UNAVAILABLE, err)
+			continue
+		}
+		reqStatus, _, err := ts.makeRelevantRequest(ctx, result.ConceptUuid, result.UppConcordance, tid)
+		if err != nil {
+			summary.fail(source, document, result.ConceptUuid, reqStatus, err)
+			continue
+		}
+		summary.record(reqStatus)
+	}
+}
+
+func (s *ReplaySummary) read() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Documents++
+}
+
+func (s *ReplaySummary) skip() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Skipped++
+}
+
+func (s *ReplaySummary) record(reqStatus status) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch reqStatus {
+	case VALID_CONCEPT:
+		s.Written++
+	case NO_CONTENT:
+		s.Deleted++
+	default:
+		s.Skipped++
+	}
+}
+
+func (s *ReplaySummary) fail(source string, document int, conceptUuid string, failureStatus status, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Failed++
+	s.Failures = append(s.Failures, ReplayFailure{Source: source, Document: document, ConceptUuid: conceptUuid, Status: failureStatus.String(), Message: singleLine(err.Error())})
+}
+
+// WriteReport prints the counts of the summary followed by a line per failure
+func (s *ReplaySummary) WriteReport(w io.Writer) {
+	fmt.Fprintf(w, "Documents read:        %d\n", s.Documents)
+	fmt.Fprintf(w, "Concordances written:  %d\n", s.Written)
+	fmt.Fprintf(w, "Concordances deleted:  %d\n", s.Deleted)
+	fmt.Fprintf(w, "Skipped:               %d\n", s.Skipped)
+	fmt.Fprintf(w, "Failed:                %d\n", s.Failed)
+	for _, failure := range s.Failures {
+		location := fmt.Sprintf("%s document %d", failure.Source, failure.Document)
+		if failure.ConceptUuid != "" {
+			location += " concept " + failure.ConceptUuid
+		}
+		fmt.Fprintf(w, "  %s: %s: %s\n", location, failure.Status, failure.Message)
+	}
+}
+
+// newDocumentDecoder reads the Smartlogic documents of an export: a JSON array, or one or more documents one after another,
+// each of which may span several lines
+func newDocumentDecoder(r io.Reader) (*batchDecoder, error) {
+	d, err := newBatchDecoder(r)
+	if err != nil || d.decoder != nil {
+		return d, err
+	}
+	return &batchDecoder{decoder: json.NewDecoder(d.reader)}, nil
+}
+
+// rateLimiter spaces requests evenly at a rate per second; a nil rateLimiter does not limit them
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / rate))}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
@@ -0,0 +1,177 @@
+package smartlogic
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// replayHttpClient answers writes with 200 and deletes with 204, like the concordances-rw-neo4j
+func replayHttpClient() *recordingHttpClient {
+	return &recordingHttpClient{respond: func(req *http.Request) (int, error) {
+		if req.Method == "DELETE" {
+			return http.StatusNoContent, nil
+		}
+		return http.StatusOK, nil
+	}}
+}
+
+func writeReplayFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "replay")
+	assert.NoError(t, err)
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestReplay(t *testing.T) {
+	dir := writeReplayFiles(t, map[string]string{
+		"1-multiple.json":   readFile(t, "../resources/multipleConcepts.json"),
+		"2-export.ndjson":   readFile(t, "../resources/noTmeIds.json") + "\n" + readFile(t, "../resources/notAllowedType.json"),
+		"3-array.json":      "[" + readFile(t, "../resources/invalidTmeId.json") + `, {"@graph": "not a graph"}]`,
+		"notes.txt":         "not an export",
+		"sub/4-broken.json": `{"@graph": [`,
+	})
+	defer os.RemoveAll(dir)
+
+	sources, err := ReplaySources([]string{dir}, nil)
+	assert.NoError(t, err)
+	var names []string
+	for _, source := range sources {
+		names = append(names, strings.TrimPrefix(source.Name, dir+string(filepath.Separator)))
+	}
+	assert.Equal(t, []string{"1-multiple.json", "2-export.ndjson", "3-array.json", filepath.Join("sub", "4-broken.json")}, names)
+
+	client := replayHttpClient()
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client)
+	summary := ts.Replay(context.Background(), sources, ReplayConfig{Concurrency: 3})
+
+	assert.Equal(t, 6, summary.Documents)
+	assert.Equal(t, 2, summary.Written)
+	assert.Equal(t, 1, summary.Deleted)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 3, summary.Failed)
+	assert.Equal(t, 3, client.calls())
+
+	var failures []string
+	for _, failure := range summary.Failures {
+		failures = append(failures, strings.TrimPrefix(failure.Source, dir+string(filepath.Separator))+" "+failure.Status)
+	}
+	assert.Equal(t, []string{"3-array.json SYNTACTICALLY_INCORRECT", "3-array.json SYNTACTICALLY_INCORRECT", filepath.Join("sub", "4-broken.json") + " SYNTACTICALLY_INCORRECT"}, failures)
+	assert.Equal(t, 0, summary.Failures[0].Document)
+	assert.Equal(t, 1, summary.Failures[1].Document)
+
+	report := &bytes.Buffer{}
+	summary.WriteReport(report)
+	assert.Contains(t, report.String(), "Concordances written:  2\n")
+	assert.Contains(t, report.String(), "3-array.json document 1: SYNTACTICALLY_INCORRECT: ")
+}
+
+func TestReplayFromStdin(t *testing.T) {
+	stdin := strings.NewReader(readFile(t, "../resources/multipleConcepts.json") + readFile(t, "../resources/noTmeIds.json"))
+	sources, err := ReplaySources(nil, stdin)
+	assert.NoError(t, err)
+	assert.Len(t, sources, 1)
+	assert.Equal(t, "stdin", sources[0].Name)
+
+	client := replayHttpClient()
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client)
+	summary := ts.Replay(context.Background(), sources, ReplayConfig{Concurrency: 1})
+	assert.Equal(t, 2, summary.Documents)
+	assert.Equal(t, 2, summary.Written)
+	assert.Equal(t, 1, summary.Deleted)
+	assert.Equal(t, 0, summary.Failed)
+	assert.Equal(t, []string{
+		"PUT " + WRITER_ADDRESS + "branches/20db1bd6-59f9-4404-adb5-3165a448f8b0",
+		"PUT " + WRITER_ADDRESS + "branches/95f00e25-9a5f-45ec-8ad8-5607d021c74b",
+		"DELETE " + WRITER_ADDRESS + "branches/20db1bd6-59f9-4404-adb5-3165a448f8b0",
+	}, client.recorded(), "A single worker should write in the order the documents were read")
+}
+
+func TestReplayKeepsConceptOrderAndKafkaMetricsApart(t *testing.T) {
+	uuids := []string{"20db1bd6-59f9-4404-adb5-3165a448f8b0", "c372ffba-7a7f-11e6-aca9-d6ece9a77557", "95f00e25-9a5f-45ec-8ad8-5607d021c74b"}
+	concept := func(uuid string, method string) string {
+		if method == "PUT" {
+			return `{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}`
+		}
+		return `{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"]}`
+	}
+	var documents []string
+	expected := map[string][]string{}
+	for i := 0; i < 12; i++ {
+		first, second := uuids[i%len(uuids)], uuids[(i+1)%len(uuids)]
+		method := "DELETE"
+		if i%2 == 0 {
+			method = "PUT"
+		}
+		expected[first] = append(expected[first], method)
+		expected[second] = append(expected[second], "PUT")
+		documents = append(documents, `{"@graph": [`+concept(first, method)+`, `+concept(second, "PUT")+`]}`)
+	}
+	sources, err := ReplaySources(nil, strings.NewReader(strings.Join(documents, "\n")))
+	assert.NoError(t, err)
+
+	queued := testutil.ToFloat64(kafkaMessagesQueued)
+	var observedQueued []float64
+	var lock sync.Mutex
+	client := &recordingHttpClient{respond: func(req *http.Request) (int, error) {
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		lock.Lock()
+		observedQueued = append(observedQueued, testutil.ToFloat64(kafkaMessagesQueued))
+		lock.Unlock()
+		if req.Method == "DELETE" {
+			return http.StatusNoContent, nil
+		}
+		return http.StatusOK, nil
+	}}
+	ts := NewTransformerService(TOPIC, WRITER_ADDRESS, client)
+	summary := ts.Replay(context.Background(), sources, ReplayConfig{Concurrency: 4})
+	assert.Equal(t, 12, summary.Documents)
+	assert.Equal(t, 0, summary.Failed)
+
+	// Every concept of a document is written in order with the other documents of that concept, not just the first one
+	for _, uuid := range uuids {
+		assert.Equal(t, expected[uuid], client.methods(uuid), "Concept "+uuid+" written out of order")
+	}
+	for _, observed := range observedQueued {
+		assert.Equal(t, queued, observed, "Replayed documents should not be counted as queued Kafka messages")
+	}
+}
+
+func TestReplaySourcesMissingPath(t *testing.T) {
+	_, err := ReplaySources([]string{"does-not-exist"}, nil)
+	assert.Error(t, err)
+}
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0))
+	assert.NoError(t, (*rateLimiter)(nil).wait(context.Background()))
+
+	limiter := newRateLimiter(100)
+	defer limiter.stop()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, limiter.wait(context.Background()))
+	}
+	assert.True(t, time.Since(start) >= 25*time.Millisecond, "Three requests at 100 per second should take about 30ms")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter = newRateLimiter(0.001)
+	defer limiter.stop()
+	assert.Equal(t, context.Canceled, limiter.wait(ctx))
+}
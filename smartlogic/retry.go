@@ -0,0 +1,134 @@
+package smartlogic
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how requests to concordances-rw-neo4j are retried.
+// A MaxAttempts of 1 or less disables retries.
+type RetryPolicy struct {
+	MaxAttempts          int
+	BaseBackoff          time.Duration
+	MaxBackoff           time.Duration
+	Jitter               float64
+	RetryableStatusCodes []int
+	RetryTransportErrors bool
+}
+
+func (p RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return p.RetryTransportErrors
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the exponential delay before the given retry, spread by +/- Jitter and capped at MaxBackoff.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.BaseBackoff) * math.Pow(2, float64(retry-1))
+	if p.Jitter > 0 {
+		delay = delay * (1 + p.Jitter*(2*rand.Float64()-1))
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	return time.Duration(delay)
+}
+
+// doWithRetry sends the request to the writer, retrying transport errors and retryable statuses as configured by the retry policy.
+// The response and error of the last attempt are returned and recorded by the circuit breaker, if any, which rejects the request outright while open.
+// Every attempt is bounded by the writer timeout, and nothing is retried once the context of the request is done.
+func (ts *TransformerService) doWithRetry(request *http.Request, breaker *circuitBreaker, uuid string, tid string) (*http.Response, error) {
+	if err := breaker.allow(ts.probeWriter); err != nil {
+		return nil, err
+	}
+	policy := ts.retryPolicy
+	for attempt := 1; ; attempt++ {
+		callCtx, cancel := ts.writerCallContext(request.Context())
+		start := time.Now()
+		resp, err := ts.httpClient.Do(request.WithContext(callCtx))
+		observeWriterCall(request.Method, start)
+		if err != nil || resp.Body == nil {
+			cancel()
+		} else {
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		}
+		if attempt >= policy.MaxAttempts || !policy.shouldRetry(resp, err) || request.Context().Err() != nil {
+			breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests)
+			if err != nil {
+				observeWriterResult(request.Method, 0, err)
+			} else {
+				observeWriterResult(request.Method, resp.StatusCode, nil)
+			}
+			if attempt > 1 {
+				log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "method": request.Method, "retries": attempt - 1}).Info("Request to writer was retried")
+			}
+			return resp, err
+		}
+
+		backoff := policy.backoff(attempt)
+		fields := log.Fields{"transaction_id": tid, "UUID": uuid, "method": request.Method, "attempt": attempt, "backoff": backoff.String()}
+		if err != nil {
+			log.WithError(err).WithFields(fields).Warn("Request to writer resulted in error; retrying")
+		} else {
+			fields["status"] = resp.StatusCode
+			log.WithFields(fields).Warn("Request to writer returned retryable status; retrying")
+		}
+		discardBody(resp)
+		if err := ts.sleep(request.Context(), backoff); err != nil {
+			return nil, err
+		}
+
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request.Body = body
+		}
+	}
+}
+
+// cancelOnClose releases the context of a call to the writer once its response body has been read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// sleepContext waits for d, returning early with the error of ctx when it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func discardBody(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -2,18 +2,20 @@ package smartlogic
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/pborman/uuid"
-
-	"github.com/Financial-Times/uuid-utils-go"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var uuidMatcher = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
@@ -23,6 +25,9 @@ type status int
 const (
 	CONCORDANCE_AUTHORITY_TME              = "TME"
 	CONCORDANCE_AUTHORITY_FACTSET          = "FACTSET"
+	CONCORDANCE_AUTHORITY_LEI              = "LEI"
+	CONCORDANCE_AUTHORITY_FIGI             = "FIGI"
+	CONCORDANCE_AUTHORITY_ISIN             = "ISIN"
 	CONCORDANCE_AUTHORITY_DBPEDIA          = "DBPedia"
 	CONCORDANCE_AUTHORITY_GEONAMES         = "Geonames"
 	CONCORDANCE_AUTHORITY_WIKIDATA         = "Wikidata"
@@ -38,6 +43,9 @@ const (
 	INTERNAL_ERROR
 	SERVICE_UNAVAILABLE
 	NO_CONTENT
+	UNCHANGED
+	TIMEOUT
+	COALESCED
 
 	alertTagConceptTypeNotAllowed = "SmartlogicConcordanceTransformerConceptTypeNotAllowed"
 )
@@ -47,345 +55,506 @@ var (
 		"skos:Concept",
 	}
 
-	errConceptTypeNotAllowed = errors.New("concept type not allowed")
+	// errConceptTypeNotAllowed matches the error of any concept whose type is not allowed
+	errConceptTypeNotAllowed = &ValidationError{Code: CONCEPT_TYPE_NOT_ALLOWED, Message: "concept type not allowed"}
 )
 
 type TransformerService struct {
 	topic         string
 	writerAddress string
 	httpClient    httpClient
+	retryPolicy   RetryPolicy
+	breaker       *circuitBreaker
+	dryRun        *dryRunRecorder
+	writeCache    *writeCache
+	writerTimeout time.Duration
+	debounce      *debouncer
+	sink          concordanceSink
+	writers       []WriterConfig
+	audit         *auditLog
+	sleep         func(context.Context, time.Duration) error
 }
 
 type httpClient interface {
 	Do(req *http.Request) (resp *http.Response, err error)
 }
 
-func NewTransformerService(topic string, writerAddress string, httpClient httpClient) TransformerService {
-	return TransformerService{
-		topic:         topic,
-		writerAddress: writerAddress,
-		httpClient:    httpClient,
-	}
-}
+// TransformerOption configures optional behaviour of the TransformerService
+type TransformerOption func(*TransformerService)
 
-func (ts *TransformerService) handleConcordanceEvent(msgBody string, tid string) error {
-	log.WithField("transaction_id", tid).Debug("Processing message with body: " + msgBody)
-	var smartLogicConceptPayload = SmartlogicConcept{}
-	decoder := json.NewDecoder(bytes.NewBufferString(msgBody))
-	err := decoder.Decode(&smartLogicConceptPayload)
-	if err != nil {
-		log.WithError(err).WithField("transaction_id", tid).Error("Failed to decode Kafka payload")
-		return err
+// WithRetryPolicy makes writes and deletes against concordances-rw-neo4j retry according to the given policy
+func WithRetryPolicy(policy RetryPolicy) TransformerOption {
+	return func(ts *TransformerService) {
+		ts.retryPolicy = policy
 	}
+}
 
-	_, conceptUuid, uppConcordance, err := convertToUppConcordance(smartLogicConceptPayload, tid)
-	if err != nil {
-		return err
-	}
-	_, err = ts.makeRelevantRequest(conceptUuid, uppConcordance, tid)
-	if err != nil {
-		return err
+// WithCircuitBreaker stops calls to concordances-rw-neo4j while its failure rate is above the configured threshold
+func WithCircuitBreaker(config CircuitBreakerConfig) TransformerOption {
+	return func(ts *TransformerService) {
+		ts.breaker = newCircuitBreaker(config)
 	}
-	log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Info("Forwarded concordance record to rw")
-	return nil
 }
 
-func convertToUppConcordance(smartlogicConcepts SmartlogicConcept, tid string) (status, string, UppConcordance, error) {
-	if len(smartlogicConcepts.Concepts) == 0 {
-		err := errors.New("Invalid Request Json: Missing/invalid @graph field")
-		log.WithField("transaction_id", tid).Error(err)
-		return SEMANTICALLY_INCORRECT, "", UppConcordance{}, err
+// WithDryRun records the requests that would have been sent to concordances-rw-neo4j instead of sending them, keeping the most recent recordSize of them
+func WithDryRun(recordSize int) TransformerOption {
+	return func(ts *TransformerService) {
+		ts.dryRun = newDryRunRecorder(recordSize)
 	}
-	if len(smartlogicConcepts.Concepts) > 1 {
-		err := errors.New("Invalid Request Json: More than 1 concept in smartlogic concept payload which is currently not supported")
-		log.WithField("transaction_id", tid).Error(err)
-		return SEMANTICALLY_INCORRECT, "", UppConcordance{}, err
-	}
-
-	smartlogicConcept := smartlogicConcepts.Concepts[0]
+}
 
-	conceptUuid, uppAuthority := extractUuidAndConcordanceAuthority(smartlogicConcept.ID)
-	if conceptUuid == "" {
-		err := errors.New("Invalid Request Json: Missing/invalid @id field")
-		log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-		return SEMANTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+// WithWriteCache skips requests to concordances-rw-neo4j that would write the same concordance as the last successful request for the concept.
+// Up to size concepts are remembered, each for at most ttl; a ttl of 0 keeps them until they are evicted.
+func WithWriteCache(size int, ttl time.Duration) TransformerOption {
+	return func(ts *TransformerService) {
+		ts.writeCache = newWriteCache(size, ttl)
 	}
+}
 
-	if len(smartlogicConcept.Types) == 0 {
-		err := fmt.Errorf("Bad Request: Type has not been set for concept: %s)", conceptUuid)
-		log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+// WithWriterTimeout abandons a call to concordances-rw-neo4j that has not completed within timeout. Every retry gets a timeout of its own.
+func WithWriterTimeout(timeout time.Duration) TransformerOption {
+	return func(ts *TransformerService) {
+		ts.writerTimeout = timeout
 	}
+}
 
-	conceptType := smartlogicConcept.Types[0]
-
-	for _, bannedConceptType := range notAllowedConceptTypes {
-		if conceptType != bannedConceptType {
-			continue
+// WithDebounce holds back the write of a concept's concordance consumed from Kafka for window after the first update of a burst,
+// sending only the latest concordance of the updates received in the meantime
+func WithDebounce(window time.Duration) TransformerOption {
+	return func(ts *TransformerService) {
+		if window > 0 {
+			ts.debounce = newDebouncer(window)
 		}
-		log.WithFields(log.Fields{
-			"transaction_id": tid,
-			"UUID":           conceptUuid,
-			"concept_type":   bannedConceptType,
-			"alert_tag":      alertTagConceptTypeNotAllowed,
-		}).Error(errConceptTypeNotAllowed)
-		return SEMANTICALLY_INCORRECT, conceptUuid, UppConcordance{}, errConceptTypeNotAllowed
 	}
+}
 
-	shortFormType := conceptType[strings.LastIndex(conceptType, "/")+1:]
-	if (shortFormType == "Membership" || shortFormType == "MembershipRole") && len(smartlogicConcept.TmeIdentifiers()) > 0 {
-		err := fmt.Errorf("Bad Request: Concept type %s does not support concordance", shortFormType)
-		log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+func NewTransformerService(topic string, writerAddress string, httpClient httpClient, opts ...TransformerOption) TransformerService {
+	ts := TransformerService{
+		topic:         topic,
+		writerAddress: writerAddress,
+		httpClient:    httpClient,
+		retryPolicy:   RetryPolicy{MaxAttempts: 1},
+		sleep:         sleepContext,
 	}
-
-	concordances := []ConcordedId{}
-
-	concordances, err := appendTmeConcordances(concordances, smartlogicConcept, conceptUuid, tid)
-
-	if err != nil {
-		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+	for _, opt := range opts {
+		opt(&ts)
 	}
+	return ts
+}
 
-	concordances, err = appendFactsetConcordances(concordances, smartlogicConcept, conceptUuid, tid)
-
-	if err != nil {
-		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+func (s status) String() string {
+	switch s {
+	case NOT_FOUND:
+		return "NOT_FOUND"
+	case SYNTACTICALLY_INCORRECT:
+		return "SYNTACTICALLY_INCORRECT"
+	case SEMANTICALLY_INCORRECT:
+		return "SEMANTICALLY_INCORRECT"
+	case VALID_CONCEPT:
+		return "VALID_CONCEPT"
+	case INTERNAL_ERROR:
+		return "INTERNAL_ERROR"
+	case SERVICE_UNAVAILABLE:
+		return "SERVICE_UNAVAILABLE"
+	case NO_CONTENT:
+		return "NO_CONTENT"
+	case UNCHANGED:
+		return "UNCHANGED"
+	case TIMEOUT:
+		return "TIMEOUT"
+	case COALESCED:
+		return "COALESCED"
+	default:
+		return "UNKNOWN"
 	}
+}
 
-	concordances, err = appendLocationConcordances(concordances, smartlogicConcept.DbpediaIdentifiers(), conceptUuid, CONCORDANCE_AUTHORITY_DBPEDIA, tid)
+func (ts *TransformerService) handleConcordanceEvent(ctx context.Context, msgBody string, tid string) (status, []ConceptResult, error) {
+	updateStatus, results, err := ts.transformConcordanceEvent(ctx, msgBody, tid)
 	if err != nil {
-		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+		return updateStatus, nil, err
 	}
-
-	concordances, err = appendLocationConcordances(concordances, smartlogicConcept.GeonamesIdentifiers(), conceptUuid, CONCORDANCE_AUTHORITY_GEONAMES, tid)
-	if err != nil {
-		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+	ts.forwardConcordances(ctx, results, tid)
+	if err := failedResultsError(results); err != nil {
+		return failedResultsStatus(results), results, err
 	}
+	return VALID_CONCEPT, results, nil
+}
 
-	concordances, err = appendLocationConcordances(concordances, smartlogicConcept.WikidataIdentifiers(), conceptUuid, CONCORDANCE_AUTHORITY_WIKIDATA, tid)
+// handleDebouncedConcordanceEvent transforms the message like handleConcordanceEvent but leaves the writes of its concordances to the debouncer.
+// When the message can be transformed, done is called with its results once every concordance has been written, has failed or has been coalesced.
+func (ts *TransformerService) handleDebouncedConcordanceEvent(ctx context.Context, msgBody string, tid string, done func([]ConceptResult)) (status, error) {
+	updateStatus, results, err := ts.transformConcordanceEvent(ctx, msgBody, tid)
 	if err != nil {
-		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+		return updateStatus, err
 	}
+	ts.debounce.forward(ctx, ts, results, tid, done)
+	return VALID_CONCEPT, nil
+}
 
-	uppConcordance := UppConcordance{
-		ConceptUuid:  conceptUuid,
-		Authority:    uppAuthority,
-		ConcordedIds: concordances,
+func (ts *TransformerService) transformConcordanceEvent(ctx context.Context, msgBody string, tid string) (status, []ConceptResult, error) {
+	log.WithField("transaction_id", tid).Debug("Processing message with body: " + msgBody)
+	var smartLogicConceptPayload = SmartlogicConcept{}
+	_, decodeSpan := startSpan(ctx, "decodeSmartlogicPayload", trace.SpanKindInternal, attribute.String("transaction_id", tid))
+	decoder := json.NewDecoder(bytes.NewBufferString(msgBody))
+	err := decoder.Decode(&smartLogicConceptPayload)
+	endSpan(decodeSpan, err)
+	if err != nil {
+		log.WithError(err).WithField("transaction_id", tid).Error("Failed to decode Kafka payload")
+		return SYNTACTICALLY_INCORRECT, nil, err
 	}
-	log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Debugf("Concordance record is %s", uppConcordance)
 
-	return VALID_CONCEPT, conceptUuid, uppConcordance, nil
+	return convertToUppConcordances(ctx, smartLogicConceptPayload, tid, failFast)
 }
 
-func appendTmeConcordances(concordances []ConcordedId, concept Concept, conceptUuid string, tid string) ([]ConcordedId, error) {
-	for _, id := range concept.TmeIdentifiers() {
-		uuidFromTmeId, err := validateTmeIdAndConvertToUuid(id.Value)
-		if conceptUuid == uuidFromTmeId {
-			err := errors.New("Bad Request: Payload from smartlogic has a smartlogic uuid that is the same as the uuid generated from the TME id")
-			log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-			return nil, err
+// forwardConcordances sends every successfully transformed concordance to the writer, recording the outcome of each request against its result.
+// A debounced update pending for the concept is superseded, as it is older than the one sent.
+func (ts *TransformerService) forwardConcordances(ctx context.Context, results []ConceptResult, tid string) {
+	for i, result := range results {
+		if result.Err != nil {
+			continue
 		}
-		if err != nil {
-			log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid, "alert_tag": "ConceptLoadingInvalidConcordance"}).Error(err)
-			return nil, err
+		ts.debounce.supersede(result.ConceptUuid)
+		reqStatus, outcomes, err := ts.makeRelevantRequest(ctx, result.ConceptUuid, result.UppConcordance, tid)
+		results[i].Status = reqStatus
+		results[i].Err = err
+		results[i].Writers = outcomes
+		if err == nil {
+			log.WithFields(log.Fields{"transaction_id": tid, "UUID": result.ConceptUuid}).Info("Forwarded concordance record to rw")
 		}
-		concordedId := ConcordedId{
-			Authority:      CONCORDANCE_AUTHORITY_TME,
-			AuthorityValue: id.Value,
-			UUID:           uuidFromTmeId,
+	}
+}
+
+// failedResultsError returns the error of a single failed concept as is, or a summary of all failures when the graph held more than one concept.
+func failedResultsError(results []ConceptResult) error {
+	var failures []string
+	var lastErr error
+	for _, result := range results {
+		if result.Err == nil {
+			continue
 		}
-		if len(concordances) > 0 {
-			for _, cid := range concordances {
-				if cid.UUID == uuidFromTmeId {
-					err := errors.New("Bad Request: Payload from smartlogic contains duplicate TME id values")
-					log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-					return nil, err
-				}
-			}
-			concordances = append(concordances, concordedId)
-		} else {
-			concordances = append(concordances, concordedId)
+		lastErr = result.Err
+		failures = append(failures, result.Err.Error())
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	if len(results) == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("%d of %d concepts in smartlogic concept payload could not be processed: %s", len(failures), len(results), strings.Join(failures, "; "))
+}
+
+// failedResultsStatus returns the status of the first failed concept, which is used to classify the failure of the payload as a whole.
+func failedResultsStatus(results []ConceptResult) status {
+	for _, result := range results {
+		if result.Err != nil {
+			return result.Status
 		}
 	}
+	return VALID_CONCEPT
+}
 
-	return concordances, nil
+// convertToUppConcordances converts every concept of the graph; in collectAll mode the error of a failed concept is a ValidationErrors listing all its problems.
+func convertToUppConcordances(ctx context.Context, smartlogicConcepts SmartlogicConcept, tid string, mode validationMode) (status, []ConceptResult, error) {
+	if len(smartlogicConcepts.Concepts) == 0 {
+		err := &ValidationError{Code: MISSING_GRAPH, Message: "Invalid Request Json: Missing/invalid @graph field"}
+		log.WithFields(err.logFields(tid)).Error(err)
+		observeTransform(SEMANTICALLY_INCORRECT, UppConcordance{})
+		return SEMANTICALLY_INCORRECT, nil, err
+	}
+
+	results := make([]ConceptResult, 0, len(smartlogicConcepts.Concepts))
+	for _, smartlogicConcept := range smartlogicConcepts.Concepts {
+		_, span := startSpan(ctx, "convertToUppConcordance", trace.SpanKindInternal, attribute.String("transaction_id", tid))
+		conceptStatus, conceptUuid, uppConcordance, err := convertToUppConcordance(smartlogicConcept, tid, mode)
+		span.SetAttributes(
+			attribute.String("concept.uuid", conceptUuid),
+			attribute.String("status", conceptStatus.String()),
+			attribute.Int("concordances", len(uppConcordance.ConcordedIds)),
+		)
+		endSpan(span, err)
+		observeTransform(conceptStatus, uppConcordance)
+		results = append(results, ConceptResult{
+			ConceptUuid:    conceptUuid,
+			Status:         conceptStatus,
+			UppConcordance: uppConcordance,
+			Err:            err,
+		})
+	}
+	return VALID_CONCEPT, results, nil
 }
 
-func appendFactsetConcordances(concordances []ConcordedId, concept Concept, conceptUuid string, tid string) ([]ConcordedId, error) {
-	for _, id := range concept.FactsetIdentifiers() {
-		uuidFromFactsetId, err := validateFactsetIdAndConvertToUuid(id.Value)
-		if conceptUuid == uuidFromFactsetId {
-			err := errors.New("Bad Request: Payload from smartlogic has a smartlogic uuid that is the same as the uuid generated from the FACTSET id")
-			log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-			return nil, err
-		}
-		if err != nil {
-			log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid, "alert_tag": "ConceptLoadingInvalidConcordance"}).Error(err)
-			return nil, err
+func convertToUppConcordance(smartlogicConcept Concept, tid string, mode validationMode) (status, string, UppConcordance, error) {
+	conceptUuid, uppAuthority := extractUuidAndConcordanceAuthority(smartlogicConcept.ID)
+	if conceptUuid == "" {
+		err := &ValidationError{Code: INVALID_CONCEPT_ID, Message: "Invalid Request Json: Missing/invalid @id field"}
+		log.WithFields(err.logFields(tid)).Error(err)
+		return SEMANTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
+	}
+
+	var problems ValidationErrors
+	if len(smartlogicConcept.Types) == 0 {
+		err := &ValidationError{Code: MISSING_CONCEPT_TYPE, Message: fmt.Sprintf("Bad Request: Type has not been set for concept: %s)", conceptUuid), ConceptUuid: conceptUuid}
+		log.WithFields(err.logFields(tid)).Error(err)
+		if mode == failFast {
+			return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
 		}
-		concordedId := ConcordedId{
-			Authority:      CONCORDANCE_AUTHORITY_FACTSET,
-			AuthorityValue: id.Value,
-			UUID:           uuidFromFactsetId,
+		problems = append(problems, err)
+	} else {
+		conceptType := smartlogicConcept.Types[0]
+
+		for _, bannedConceptType := range notAllowedConceptTypes {
+			if conceptType != bannedConceptType {
+				continue
+			}
+			err := &ValidationError{Code: CONCEPT_TYPE_NOT_ALLOWED, Message: fmt.Sprintf("%s: %s", errConceptTypeNotAllowed.Message, conceptType), ConceptUuid: conceptUuid, ConceptType: conceptType}
+			log.WithFields(err.logFields(tid)).WithField("alert_tag", alertTagConceptTypeNotAllowed).Error(err)
+			return SEMANTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
 		}
-		if len(concordances) > 0 {
-			for _, cid := range concordances {
-				if cid.UUID == uuidFromFactsetId {
-					err := errors.New("Bad Request: Payload from smartlogic contains duplicate FACTSET id values")
-					log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-					return nil, err
-				}
+
+		shortFormType := conceptType[strings.LastIndex(conceptType, "/")+1:]
+		if (shortFormType == "Membership" || shortFormType == "MembershipRole") && len(smartlogicConcept.Identifiers(CONCORDANCE_AUTHORITY_TME)) > 0 {
+			err := &ValidationError{Code: CONCORDANCE_NOT_SUPPORTED, Message: fmt.Sprintf("Bad Request: Concept type %s does not support concordance", shortFormType), ConceptUuid: conceptUuid}
+			log.WithFields(err.logFields(tid)).Error(err)
+			if mode == failFast {
+				return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, err
 			}
-			concordances = append(concordances, concordedId)
-		} else {
-			concordances = append(concordances, concordedId)
+			problems = append(problems, err)
+		}
+	}
+
+	concordances := []ConcordedId{}
+	for _, authority := range authorities.all() {
+		var errs ValidationErrors
+		concordances, errs = appendConcordances(concordances, authority, smartlogicConcept.identifiers[authority.Name], conceptUuid, tid, mode)
+		if len(errs) > 0 && mode == failFast {
+			return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, errs[0]
 		}
+		problems = append(problems, errs...)
+	}
+	if len(problems) > 0 {
+		return SYNTACTICALLY_INCORRECT, conceptUuid, UppConcordance{}, problems
 	}
 
-	return concordances, nil
+	uppConcordance := UppConcordance{
+		ConceptUuid:  conceptUuid,
+		Authority:    uppAuthority,
+		ConcordedIds: concordances,
+	}
+	log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Debugf("Concordance record is %s", uppConcordance)
+
+	return VALID_CONCEPT, conceptUuid, uppConcordance, nil
 }
 
-func appendLocationConcordances(concordances []ConcordedId, conceptIdentifiers []LocationType, conceptUuid string, authority string, tid string) ([]ConcordedId, error) {
-	for _, id := range conceptIdentifiers {
-		if len(strings.TrimSpace(id.Value)) == 0 {
-			log.WithFields(log.Fields{"transaction_id": tid, "uuid": conceptUuid}).Warn(fmt.Sprintf("Payload from Smartlogic contains one or more empty %v values. Skipping it", authority))
+// appendConcordances adds the concordances of one authority, returning the problems found with its identifiers. In failFast mode
+// it stops at the first problem; in collectAll mode it goes on to check the remaining identifiers.
+func appendConcordances(concordances []ConcordedId, authority Authority, identifiers []conceptIdentifier, conceptUuid string, tid string, mode validationMode) ([]ConcordedId, ValidationErrors) {
+	var problems ValidationErrors
+	for _, identifier := range identifiers {
+		value := identifier.Value
+		if authority.SkipBlank && len(strings.TrimSpace(value)) == 0 {
+			log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Warn(fmt.Sprintf("Payload from Smartlogic contains one or more empty %v values. Skipping it", authority.Name))
 			continue
 		}
 
-		uuidFromConceptIdentifier := convertToUuid(id.Value)
-		if conceptUuid == uuidFromConceptIdentifier {
-			err := fmt.Errorf("Bad Request: Payload from Smartlogic has a Smartlogic uuid that is the same as the uuid generated from %v id", authority)
-			log.WithFields(log.Fields{"transaction_id": tid, "uuid": conceptUuid}).Error(err)
-			return nil, err
+		var problem *ValidationError
+		uuidFromValue, err := authority.convert(value)
+		switch {
+		case err != nil:
+			problem = newIdentifierError(INVALID_IDENTIFIER, err.Error(), conceptUuid, authority.Name, identifier)
+			log.WithFields(problem.logFields(tid)).WithField("alert_tag", "ConceptLoadingInvalidConcordance").Error(problem)
+		case conceptUuid == uuidFromValue:
+			problem = newIdentifierError(SELF_CONCORDANCE, fmt.Sprintf("Bad Request: Payload from smartlogic has a smartlogic uuid that is the same as the uuid generated from the %v id", authority.Name), conceptUuid, authority.Name, identifier)
+			log.WithFields(problem.logFields(tid)).Error(problem)
+		case concordancesContainValue(concordances, uuidFromValue):
+			if authority.OnDuplicate == DuplicateSkip {
+				log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Warn(fmt.Sprintf("Payload from Smartlogic contains duplicate %v values. Skipping it", authority.Name))
+				continue
+			}
+			problem = newIdentifierError(DUPLICATE_IDENTIFIER, fmt.Sprintf("Bad Request: Payload from smartlogic contains duplicate %v id values", authority.Name), conceptUuid, authority.Name, identifier)
+			log.WithFields(problem.logFields(tid)).Error(problem)
 		}
-		if concordancesContainValue(concordances, uuidFromConceptIdentifier) {
-			log.WithFields(log.Fields{"transaction_id": tid, "uuid": conceptUuid}).Warn(fmt.Sprintf("Payload from Smartlogic contains duplicate %v values. Skipping it", authority))
+		if problem != nil {
+			problems = append(problems, problem)
+			if mode == failFast {
+				return concordances, problems
+			}
 			continue
 		}
 
-		concordedId := ConcordedId{
-			Authority:      authority,
-			AuthorityValue: id.Value,
-			UUID:           uuidFromConceptIdentifier,
-		}
-		if len(concordances) > 0 {
-			for _, cid := range concordances {
-				if cid.UUID == uuidFromConceptIdentifier {
-					err := errors.New("Bad Request: Payload from smartlogic contains duplicate " + authority + " id values")
-					log.WithFields(log.Fields{"transaction_id": tid, "UUID": conceptUuid}).Error(err)
-					return nil, err
-				}
-			}
-			concordances = append(concordances, concordedId)
-		} else {
-			concordances = append(concordances, concordedId)
-		}
+		concordances = append(concordances, ConcordedId{
+			Authority:      authority.Name,
+			AuthorityValue: value,
+			UUID:           uuidFromValue,
+		})
 	}
 
-	return concordances, nil
+	return concordances, problems
 }
 
-func validateTmeIdAndConvertToUuid(tmeId string) (string, error) {
-	subStrings := strings.Split(tmeId, "-")
-	if len(subStrings) != 2 || !validateSubstrings(subStrings) {
-		return "", errors.New("Bad Request: Concordance id " + tmeId + " is not a valid TME Id")
-	} else {
-		return uuid.NewMD5(uuid.UUID{}, []byte(tmeId)).String(), nil
+// makeRelevantRequest writes or deletes the concordance of the concept. When concordances are fanned out to several writers,
+// the outcome of every writer is returned as well.
+func (ts *TransformerService) makeRelevantRequest(ctx context.Context, uuid string, uppConcordance UppConcordance, tid string) (status, []WriterOutcome, error) {
+	if ts.dryRun != nil {
+		reqStatus, err := ts.makeDryRunRequest(uuid, uppConcordance, tid)
+		return reqStatus, nil, err
 	}
-}
 
-func validateFactsetIdAndConvertToUuid(factsetId string) (string, error) {
-	if len(factsetId) != 8 || factsetId[0] != '0' || factsetId[6:8] != "-E" {
-		return "", errors.New("Bad Request: Concordance id " + factsetId + " is not a valid FACTSET Id")
+	if ts.writeCache.unchanged(uuid, uppConcordance) {
+		log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Info("Concordance record unchanged since last write; not forwarding request to writer")
+		return UNCHANGED, nil, nil
 	}
-	return uuidutils.DeriveFactsetUUID(factsetId), nil
-}
 
-func convertToUuid(id string) string {
-	return uuid.NewMD5(uuid.UUID{}, []byte(id)).String()
-}
-
-func validateSubstrings(subStrings []string) bool {
-	for _, string := range subStrings {
-		if string == "" {
-			return false
-		}
-	}
-	return true
-}
-
-func (ts *TransformerService) makeRelevantRequest(uuid string, uppConcordance UppConcordance, tid string) (status, error) {
 	var err error
 	var reqStatus status
+	var outcomes []WriterOutcome
+	method := "PUT"
 	if len(uppConcordance.ConcordedIds) > 0 {
 		log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Infof("Concordance record is: %s; forwarding request to writer", uppConcordance)
-		reqStatus, err = ts.makeWriteRequest(uuid, uppConcordance, tid)
+		reqStatus, outcomes, err = ts.concordanceSink().write(ctx, uuid, uppConcordance, tid)
 	} else {
 		log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Debug("No concordance found; making delete request")
-		reqStatus, err = ts.makeDeleteRequest(uuid, tid)
+		method = "DELETE"
+		reqStatus, outcomes, err = ts.concordanceSink().delete(ctx, uuid, tid)
 	}
+	ts.audit.record(ctx, uuid, method, uppConcordance, tid, reqStatus, outcomes, err)
 
-	return reqStatus, err
+	// A concordance is only cached once every writer has it, so that a best-effort writer that failed catches up on the next delivery
+	if err != nil || anyWriterFailed(outcomes) {
+		ts.writeCache.forget(uuid)
+	} else {
+		ts.writeCache.written(uuid, uppConcordance)
+	}
+	return reqStatus, outcomes, err
 }
 
-func (ts *TransformerService) makeWriteRequest(uuid string, uppConcordance UppConcordance, tid string) (status, error) {
-	reqURL := ts.writerAddress + "branches/" + uuid
+func (ts *TransformerService) makeWriteRequest(ctx context.Context, writer httpWriterSink, uuid string, uppConcordance UppConcordance, tid string) (status, error) {
+	reqURL := writer.address + "branches/" + uuid
+	ctx, span := startSpan(ctx, "makeWriteRequest", trace.SpanKindClient, writerSpanAttributes(uuid, tid, "PUT", reqURL)...)
 	concordedJson, err := json.Marshal(uppConcordance)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Bad Request: Could not unmarshall concordance json")
+		endSpan(span, err)
 		return SYNTACTICALLY_INCORRECT, err
 	}
 
-	request, err := http.NewRequest("PUT", reqURL, strings.NewReader(string(concordedJson)))
+	request, err := http.NewRequestWithContext(ctx, "PUT", reqURL, strings.NewReader(string(concordedJson)))
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Internal Error: Failed to create GET request to " + reqURL + " with body " + string(concordedJson))
+		endSpan(span, err)
 		return INTERNAL_ERROR, err
 	}
 	request.ContentLength = -1
 	request.Header.Set("X-Request-Id", tid)
+	injectTraceContext(ctx, request)
 
-	resp, err := ts.httpClient.Do(request)
+	resp, err := ts.doWithRetry(request, writer.breaker, uuid, tid)
 	if err != nil {
+		if isTimeout(err) {
+			log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Gateway Timeout: Put request to writer timed out")
+			endSpan(span, err)
+			return TIMEOUT, err
+		}
 		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Service Unavailable: Get request to writer resulted in error")
+		endSpan(span, err)
 		return SERVICE_UNAVAILABLE, err
-	} else if resp.StatusCode != 200 && resp.StatusCode != 201 && resp.StatusCode != 304 {
+	}
+	// The body is closed on every path, as closing it releases the context of the call to the writer
+	defer discardBody(resp)
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode != 200 && resp.StatusCode != 201 && resp.StatusCode != 304 {
 		err := errors.New("Internal Error: Get request to writer returned unexpected status: " + strconv.Itoa(resp.StatusCode))
 		log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "status": resp.StatusCode}).Error(err)
+		endSpan(span, err)
 		return INTERNAL_ERROR, err
 	}
 
-	defer resp.Body.Close()
+	endSpan(span, nil)
 	return VALID_CONCEPT, nil
 }
 
-func (ts *TransformerService) makeDeleteRequest(uuid string, tid string) (status, error) {
-	reqURL := ts.writerAddress + "branches/" + uuid
-	request, err := http.NewRequest("DELETE", reqURL, strings.NewReader(""))
+func (ts *TransformerService) makeDeleteRequest(ctx context.Context, writer httpWriterSink, uuid string, tid string) (status, error) {
+	reqURL := writer.address + "branches/" + uuid
+	ctx, span := startSpan(ctx, "makeDeleteRequest", trace.SpanKindClient, writerSpanAttributes(uuid, tid, "DELETE", reqURL)...)
+	request, err := http.NewRequestWithContext(ctx, "DELETE", reqURL, strings.NewReader(""))
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Internal Error: Failed to create DELETE request to " + reqURL)
+		endSpan(span, err)
 		return INTERNAL_ERROR, err
 	}
 	request.ContentLength = -1
 	request.Header.Set("X-Request-Id", tid)
+	injectTraceContext(ctx, request)
 
-	resp, err := ts.httpClient.Do(request)
+	resp, err := ts.doWithRetry(request, writer.breaker, uuid, tid)
 
 	if err != nil {
+		if isTimeout(err) {
+			log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Gateway Timeout: Delete request to writer timed out")
+			endSpan(span, err)
+			return TIMEOUT, err
+		}
 		log.WithError(err).WithFields(log.Fields{"transaction_id": tid, "UUID": uuid}).Error("Service Unavailable: Delete request to writer resulted in error")
+		endSpan(span, err)
 		return SERVICE_UNAVAILABLE, err
-	} else if resp.StatusCode != 204 && resp.StatusCode != 404 {
+	}
+	defer discardBody(resp)
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode != 204 && resp.StatusCode != 404 {
 		err := errors.New("Internal Error: Delete request to writer returned unexpected status: " + strconv.Itoa(resp.StatusCode))
 		log.WithFields(log.Fields{"transaction_id": tid, "UUID": uuid, "status": resp.StatusCode}).Error(err)
+		endSpan(span, err)
 		return INTERNAL_ERROR, err
 	}
-	defer resp.Body.Close()
+	endSpan(span, nil)
 	if resp.StatusCode == 204 {
 		return NO_CONTENT, nil
 	}
 	return NOT_FOUND, nil
 }
 
+// probeWriter checks that concordances-rw-neo4j is good to go
+func (ts *TransformerService) probeWriter() error {
+	return ts.probeWriterAt(ts.writerAddress)
+}
+
+// probeWriterAt checks that the writer at address is good to go
+func (ts *TransformerService) probeWriterAt(address string) error {
+	ctx, cancel := ts.writerCallContext(context.Background())
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "GET", address+"__gtg", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ts.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Writer __gtg returned status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// writerCallContext bounds a single call to concordances-rw-neo4j by the writer timeout, if one is configured
+func (ts *TransformerService) writerCallContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ts.writerTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, ts.writerTimeout)
+}
+
+// isTimeout tells a writer that did not answer in time apart from one that could not be reached at all
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 func extractUuidAndConcordanceAuthority(url string) (string, string) {
 	if strings.HasPrefix(url, THING_URI_PREFIX) {
 		extractedUuid := strings.TrimPrefix(url, THING_URI_PREFIX)
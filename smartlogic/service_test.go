@@ -2,10 +2,15 @@ package smartlogic
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -67,7 +72,7 @@ func TestValidateTmeIdAndConvertToUuid(t *testing.T) {
 	testScenarios := []testStruct{invalidTmeIdHasNoHyphen, invalidTmeIdHasNoTaxonomy, invalidTmeIdHasNoValue, invalidTmeIdHasTooManyParts, validTmeIdIsConverted}
 
 	for _, scenario := range testScenarios {
-		uuid, err := validateTmeIdAndConvertToUuid(scenario.tmeId)
+		uuid, err := tmeAuthority.convert(scenario.tmeId)
 		assert.Equal(t, scenario.expectedUuid, uuid, "Scenario: "+scenario.testName+" failed")
 		assert.Equal(t, scenario.expectedError, err, "Scenario: "+scenario.testName+" failed")
 	}
@@ -89,7 +94,77 @@ func TestValidateFactsetIdAndConvertToUuid(t *testing.T) {
 	testScenarios := []testStruct{invalidFactsetIdNoZeroPrefix, invalidFactsetINoESuffix, invalidFactsetIdNoHyphenSuffix, validFactsetIdIsConverted}
 
 	for _, scenario := range testScenarios {
-		uuid, err := validateFactsetIdAndConvertToUuid(scenario.factsetId)
+		uuid, err := factsetAuthority.convert(scenario.factsetId)
+		assert.Equal(t, scenario.expectedUuid, uuid, "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedError, err, "Scenario: "+scenario.testName+" failed")
+	}
+}
+
+func TestValidateLeiIdAndConvertToUuid(t *testing.T) {
+	type testStruct struct {
+		testName      string
+		leiId         string
+		expectedUuid  string
+		expectedError error
+	}
+
+	invalidLeiIdTooShort := testStruct{testName: "invalidLeiIdTooShort", leiId: "7LTWFZYICNSX8D621K8", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id 7LTWFZYICNSX8D621K8 is not a valid LEI Id")}
+	invalidLeiIdLowerCase := testStruct{testName: "invalidLeiIdLowerCase", leiId: "7ltwfzyicnsx8d621k86", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id 7ltwfzyicnsx8d621k86 is not a valid LEI Id")}
+	invalidLeiIdLetterCheckDigits := testStruct{testName: "invalidLeiIdLetterCheckDigits", leiId: "7LTWFZYICNSX8D621KAB", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id 7LTWFZYICNSX8D621KAB is not a valid LEI Id")}
+	invalidLeiIdCheckDigits := testStruct{testName: "invalidLeiIdCheckDigits", leiId: "7LTWFZYICNSX8D621K87", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id 7LTWFZYICNSX8D621K87 is not a valid LEI Id")}
+	validLeiIdIsConverted := testStruct{testName: "validLeiIdIsConverted", leiId: "7LTWFZYICNSX8D621K86", expectedUuid: "74612b90-f395-380b-9931-ffc3027d58c3", expectedError: nil}
+
+	testScenarios := []testStruct{invalidLeiIdTooShort, invalidLeiIdLowerCase, invalidLeiIdLetterCheckDigits, invalidLeiIdCheckDigits, validLeiIdIsConverted}
+
+	for _, scenario := range testScenarios {
+		uuid, err := leiAuthority.convert(scenario.leiId)
+		assert.Equal(t, scenario.expectedUuid, uuid, "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedError, err, "Scenario: "+scenario.testName+" failed")
+	}
+}
+
+func TestValidateFigiIdAndConvertToUuid(t *testing.T) {
+	type testStruct struct {
+		testName      string
+		figiId        string
+		expectedUuid  string
+		expectedError error
+	}
+
+	invalidFigiIdNoBbgPrefix := testStruct{testName: "invalidFigiIdNoBbgPrefix", figiId: "KKG000B9XRY4", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id KKG000B9XRY4 is not a valid FIGI Id")}
+	invalidFigiIdTooLong := testStruct{testName: "invalidFigiIdTooLong", figiId: "BBG000B9XRY44", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id BBG000B9XRY44 is not a valid FIGI Id")}
+	invalidFigiIdVowel := testStruct{testName: "invalidFigiIdVowel", figiId: "BBG000B9XRA4", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id BBG000B9XRA4 is not a valid FIGI Id")}
+	invalidFigiIdCheckDigit := testStruct{testName: "invalidFigiIdCheckDigit", figiId: "BBG000B9XRY5", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id BBG000B9XRY5 is not a valid FIGI Id")}
+	validFigiIdIsConverted := testStruct{testName: "validFigiIdIsConverted", figiId: "BBG000B9XRY4", expectedUuid: "c080ca5b-ce4d-38e4-9394-d75889bf3407", expectedError: nil}
+
+	testScenarios := []testStruct{invalidFigiIdNoBbgPrefix, invalidFigiIdTooLong, invalidFigiIdVowel, invalidFigiIdCheckDigit, validFigiIdIsConverted}
+
+	for _, scenario := range testScenarios {
+		uuid, err := figiAuthority.convert(scenario.figiId)
+		assert.Equal(t, scenario.expectedUuid, uuid, "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedError, err, "Scenario: "+scenario.testName+" failed")
+	}
+}
+
+func TestValidateIsinIdAndConvertToUuid(t *testing.T) {
+	type testStruct struct {
+		testName      string
+		isinId        string
+		expectedUuid  string
+		expectedError error
+	}
+
+	invalidIsinIdTooShort := testStruct{testName: "invalidIsinIdTooShort", isinId: "US037833100", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id US037833100 is not a valid ISIN Id")}
+	invalidIsinIdNumericCountry := testStruct{testName: "invalidIsinIdNumericCountry", isinId: "120378331005", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id 120378331005 is not a valid ISIN Id")}
+	invalidIsinIdLetterCheckDigit := testStruct{testName: "invalidIsinIdLetterCheckDigit", isinId: "US037833100A", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id US037833100A is not a valid ISIN Id")}
+	invalidIsinIdCheckDigit := testStruct{testName: "invalidIsinIdCheckDigit", isinId: "US0378331006", expectedUuid: "", expectedError: errors.New("Bad Request: Concordance id US0378331006 is not a valid ISIN Id")}
+	validIsinIdIsConverted := testStruct{testName: "validIsinIdIsConverted", isinId: "US0378331005", expectedUuid: "639f0ab3-9210-371b-9a44-5ef02a1fa48d", expectedError: nil}
+	validIsinIdWithLettersIsConverted := testStruct{testName: "validIsinIdWithLettersIsConverted", isinId: "GB0002634946", expectedUuid: convertToUuid("GB0002634946"), expectedError: nil}
+
+	testScenarios := []testStruct{invalidIsinIdTooShort, invalidIsinIdNumericCountry, invalidIsinIdLetterCheckDigit, invalidIsinIdCheckDigit, validIsinIdIsConverted, validIsinIdWithLettersIsConverted}
+
+	for _, scenario := range testScenarios {
+		uuid, err := isinAuthority.convert(scenario.isinId)
 		assert.Equal(t, scenario.expectedUuid, uuid, "Scenario: "+scenario.testName+" failed")
 		assert.Equal(t, scenario.expectedError, err, "Scenario: "+scenario.testName+" failed")
 	}
@@ -139,7 +214,7 @@ func TestMakeRelevantRequest(t *testing.T) {
 
 	for _, scenario := range testScenarios {
 		ts := NewTransformerService("", writerUrl, mockHttpClient{resp: scenario.clientResp, statusCode: scenario.statusCode, err: scenario.clientErr})
-		_, reqErr := ts.makeRelevantRequest(scenario.uuid, scenario.uppConcordance, "")
+		_, _, reqErr := ts.makeRelevantRequest(context.Background(), scenario.uuid, scenario.uppConcordance, "")
 		if reqErr != nil {
 			assert.Contains(t, reqErr.Error(), scenario.expectedError.Error(), "Scenario: "+scenario.testName+" failed")
 		} else {
@@ -148,6 +223,174 @@ func TestMakeRelevantRequest(t *testing.T) {
 	}
 }
 
+func TestMakeRelevantRequestRetries(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{concordedTmeId}}
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+	policy := RetryPolicy{
+		MaxAttempts:          3,
+		BaseBackoff:          100 * time.Millisecond,
+		MaxBackoff:           150 * time.Millisecond,
+		RetryableStatusCodes: []int{429, 502, 503, 504},
+		RetryTransportErrors: true,
+	}
+	transportErr := errors.New("connection refused")
+
+	type testStruct struct {
+		testName         string
+		uppConcordance   UppConcordance
+		policy           RetryPolicy
+		statusCodes      []int
+		errs             []error
+		expectedStatus   status
+		expectedCalls    int
+		expectedBackoffs []time.Duration
+	}
+
+	scenarios := []testStruct{
+		{testName: "writeSucceedsAfterRetryableStatus", uppConcordance: withConcordance, policy: policy, statusCodes: []int{503, 502, 200}, errs: []error{nil, nil, nil}, expectedStatus: VALID_CONCEPT, expectedCalls: 3, expectedBackoffs: []time.Duration{100 * time.Millisecond, 150 * time.Millisecond}},
+		{testName: "deleteSucceedsAfterTransportError", uppConcordance: noConcordance, policy: policy, statusCodes: []int{0, 204}, errs: []error{transportErr, nil}, expectedStatus: NO_CONTENT, expectedCalls: 2, expectedBackoffs: []time.Duration{100 * time.Millisecond}},
+		{testName: "writeGivesUpAfterMaxAttempts", uppConcordance: withConcordance, policy: policy, statusCodes: []int{503, 503, 503}, errs: []error{nil, nil, nil}, expectedStatus: INTERNAL_ERROR, expectedCalls: 3, expectedBackoffs: []time.Duration{100 * time.Millisecond, 150 * time.Millisecond}},
+		{testName: "writeDoesNotRetryNonRetryableStatus", uppConcordance: withConcordance, policy: policy, statusCodes: []int{400}, errs: []error{nil}, expectedStatus: INTERNAL_ERROR, expectedCalls: 1},
+		{testName: "deleteDoesNotRetryTransportErrorsWhenDisabled", uppConcordance: noConcordance, policy: RetryPolicy{MaxAttempts: 3, RetryTransportErrors: false}, statusCodes: []int{0}, errs: []error{transportErr}, expectedStatus: SERVICE_UNAVAILABLE, expectedCalls: 1},
+	}
+
+	for _, scenario := range scenarios {
+		client := &recordingHttpClient{statusCodes: scenario.statusCodes, errs: scenario.errs}
+		ts := NewTransformerService("", writerUrl, client, WithRetryPolicy(scenario.policy))
+		var backoffs []time.Duration
+		ts.sleep = func(ctx context.Context, d time.Duration) error {
+			backoffs = append(backoffs, d)
+			return nil
+		}
+
+		reqStatus, _, _ := ts.makeRelevantRequest(context.Background(), testUuid, scenario.uppConcordance, "tid_test")
+		assert.Equal(t, scenario.expectedStatus, reqStatus, "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedCalls, client.calls(), "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedBackoffs, backoffs, "Scenario: "+scenario.testName+" failed")
+	}
+}
+
+// hangUntilDone answers a request only once it has been cancelled or its deadline has passed, like a writer that hangs
+func hangUntilDone(req *http.Request) (int, error) {
+	<-req.Context().Done()
+	return 0, &url.Error{Op: req.Method, URL: req.URL.String(), Err: req.Context().Err()}
+}
+
+func TestMakeRelevantRequestDeadlines(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{concordedTmeId}}
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+	policy := RetryPolicy{MaxAttempts: 2, RetryTransportErrors: true}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	type testStruct struct {
+		testName       string
+		uppConcordance UppConcordance
+		ctx            context.Context
+		expectedStatus status
+		expectedErr    error
+		expectedCalls  int
+	}
+
+	scenarios := []testStruct{
+		{testName: "writeTimesOutOnEveryAttempt", uppConcordance: withConcordance, ctx: context.Background(), expectedStatus: TIMEOUT, expectedErr: context.DeadlineExceeded, expectedCalls: 2},
+		{testName: "deleteTimesOutOnEveryAttempt", uppConcordance: noConcordance, ctx: context.Background(), expectedStatus: TIMEOUT, expectedErr: context.DeadlineExceeded, expectedCalls: 2},
+		{testName: "cancelledWriteIsNotRetried", uppConcordance: withConcordance, ctx: cancelled, expectedStatus: SERVICE_UNAVAILABLE, expectedErr: context.Canceled, expectedCalls: 1},
+		{testName: "cancelledDeleteIsNotRetried", uppConcordance: noConcordance, ctx: cancelled, expectedStatus: SERVICE_UNAVAILABLE, expectedErr: context.Canceled, expectedCalls: 1},
+	}
+
+	for _, scenario := range scenarios {
+		client := &recordingHttpClient{respond: hangUntilDone}
+		ts := NewTransformerService("", writerUrl, client, WithRetryPolicy(policy), WithWriterTimeout(10*time.Millisecond))
+		ts.sleep = func(ctx context.Context, d time.Duration) error {
+			return ctx.Err()
+		}
+
+		reqStatus, _, err := ts.makeRelevantRequest(scenario.ctx, testUuid, scenario.uppConcordance, "tid_test")
+		assert.Equal(t, scenario.expectedStatus, reqStatus, "Scenario: "+scenario.testName+" failed")
+		assert.True(t, errors.Is(err, scenario.expectedErr), "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedCalls, client.calls(), "Scenario: "+scenario.testName+" failed")
+	}
+}
+
+func TestWriterResponseBodiesAreClosed(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{concordedTmeId}}
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+
+	type testStruct struct {
+		testName       string
+		request        func(ts TransformerService) (status, error)
+		statusCode     int
+		expectedStatus status
+	}
+
+	write := func(ts TransformerService) (status, error) {
+		reqStatus, _, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_test")
+		return reqStatus, err
+	}
+	remove := func(ts TransformerService) (status, error) {
+		reqStatus, _, err := ts.makeRelevantRequest(context.Background(), testUuid, noConcordance, "tid_test")
+		return reqStatus, err
+	}
+	read := func(ts TransformerService) (status, error) {
+		reqStatus, _, err := ts.getCurrentConcordance(context.Background(), testUuid, "tid_test")
+		return reqStatus, err
+	}
+
+	scenarios := []testStruct{
+		{testName: "writeSucceeds", request: write, statusCode: 200, expectedStatus: VALID_CONCEPT},
+		{testName: "writeIsRejected", request: write, statusCode: 400, expectedStatus: INTERNAL_ERROR},
+		{testName: "writeFails", request: write, statusCode: 500, expectedStatus: INTERNAL_ERROR},
+		{testName: "deleteSucceeds", request: remove, statusCode: 204, expectedStatus: NO_CONTENT},
+		{testName: "deleteIsRejected", request: remove, statusCode: 400, expectedStatus: INTERNAL_ERROR},
+		{testName: "deleteFails", request: remove, statusCode: 503, expectedStatus: INTERNAL_ERROR},
+		{testName: "readFindsNothing", request: read, statusCode: 404, expectedStatus: NOT_FOUND},
+		{testName: "readFails", request: read, statusCode: 500, expectedStatus: INTERNAL_ERROR},
+	}
+
+	for _, scenario := range scenarios {
+		var callCtx context.Context
+		client := &recordingHttpClient{}
+		client.respond = func(req *http.Request) (int, error) {
+			callCtx = req.Context()
+			return scenario.statusCode, nil
+		}
+		ts := NewTransformerService("", writerUrl, client, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}), WithWriterTimeout(0))
+
+		reqStatus, _ := scenario.request(ts)
+		assert.Equal(t, scenario.expectedStatus, reqStatus, "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, 1, client.calls(), "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, 0, client.openBodies(), "Scenario: "+scenario.testName+" failed: the response body was not closed")
+		assert.Equal(t, context.Canceled, callCtx.Err(), "Scenario: "+scenario.testName+" failed: the context of the call was not released")
+	}
+}
+
+func TestRetryBackoffIsCutShortByCancellation(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{concordedTmeId}}
+	client := &recordingHttpClient{statusCodes: []int{503, 200}, errs: []error{nil, nil}}
+	ts := NewTransformerService("", writerUrl, client, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Minute, RetryableStatusCodes: []int{503}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	reqStatus, _, err := ts.makeRelevantRequest(ctx, testUuid, withConcordance, "tid_test")
+	assert.Equal(t, TIMEOUT, reqStatus)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, client.calls(), "The retry should not be attempted once the deadline has passed")
+}
+
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	policy := RetryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		for retry := 1; retry <= 5; retry++ {
+			backoff := policy.backoff(retry)
+			expected := 100 * time.Millisecond << uint(retry-1)
+			assert.True(t, backoff >= expected/2 && backoff <= expected*3/2, "backoff %v for retry %d outside jitter range", backoff, retry)
+			assert.True(t, backoff <= time.Second, "backoff %v for retry %d beyond the maximum backoff", backoff, retry)
+		}
+	}
+}
+
 func TestConvertToUppConcordance(t *testing.T) {
 	noConcordance := UppConcordance{ConceptUuid: ""}
 	emptyConcordance := UppConcordance{
@@ -197,6 +440,44 @@ func TestConvertToUppConcordance(t *testing.T) {
 			},
 		},
 	}
+	factsetLeiConcordance := UppConcordance{
+		ConceptUuid: testUuid,
+		Authority:   "Smartlogic",
+		ConcordedIds: []ConcordedId{
+			ConcordedId{
+				Authority:      CONCORDANCE_AUTHORITY_FACTSET,
+				AuthorityValue: "000D63-E",
+				UUID:           "8d3aba95-02d9-3802-afc0-b99bb9b1139e",
+			}, ConcordedId{
+				Authority:      CONCORDANCE_AUTHORITY_LEI,
+				AuthorityValue: "7LTWFZYICNSX8D621K86",
+				UUID:           "74612b90-f395-380b-9931-ffc3027d58c3",
+			}, ConcordedId{
+				Authority:      CONCORDANCE_AUTHORITY_LEI,
+				AuthorityValue: "5493001KJTIIGC8Y1R12",
+				UUID:           "3cf9c13f-3bdf-342f-8258-65a4394b12ab",
+			},
+		},
+	}
+	financialInstrumentConcordance := UppConcordance{
+		ConceptUuid: testUuid,
+		Authority:   "Smartlogic",
+		ConcordedIds: []ConcordedId{
+			ConcordedId{
+				Authority:      CONCORDANCE_AUTHORITY_FIGI,
+				AuthorityValue: "BBG000B9XRY4",
+				UUID:           "c080ca5b-ce4d-38e4-9394-d75889bf3407",
+			}, ConcordedId{
+				Authority:      CONCORDANCE_AUTHORITY_ISIN,
+				AuthorityValue: "US0378331005",
+				UUID:           "639f0ab3-9210-371b-9a44-5ef02a1fa48d",
+			}, ConcordedId{
+				Authority:      CONCORDANCE_AUTHORITY_ISIN,
+				AuthorityValue: "US5949181045",
+				UUID:           "9f8fe31f-b9dc-38b6-968b-a8e58c0673d5",
+			},
+		},
+	}
 	multiTmeFactsetConcordance := UppConcordance{
 		ConceptUuid: testUuid,
 		Authority:   "ManagedLocation",
@@ -363,6 +644,62 @@ func TestConvertToUppConcordance(t *testing.T) {
 		uppConcordance: noConcordance,
 		expectedError:  errors.New("contains duplicate FACTSET id values"),
 	}
+	handlesLeiIds := testStruct{
+		testName:       "handlesLeiIds",
+		pathToFile:     "../resources/leiIds.json",
+		conceptUuid:    testUuid,
+		uppConcordance: factsetLeiConcordance,
+		expectedError:  nil,
+	}
+	invalidLeiId := testStruct{
+		testName:       "invalidLeiId",
+		pathToFile:     "../resources/invalidLeiId.json",
+		conceptUuid:    testUuid,
+		uppConcordance: noConcordance,
+		expectedError:  errors.New("is not a valid LEI Id"),
+	}
+	errorOnDuplicateLeiIds := testStruct{
+		testName:       "errorOnDuplicateLeiIds",
+		pathToFile:     "../resources/duplicateLeiIds.json",
+		conceptUuid:    testUuid,
+		uppConcordance: noConcordance,
+		expectedError:  errors.New("contains duplicate LEI id values"),
+	}
+	handlesFinancialInstrumentIds := testStruct{
+		testName:       "handlesFinancialInstrumentIds",
+		pathToFile:     "../resources/financialInstrumentIds.json",
+		conceptUuid:    testUuid,
+		uppConcordance: financialInstrumentConcordance,
+		expectedError:  nil,
+	}
+	invalidFigiId := testStruct{
+		testName:       "invalidFigiId",
+		pathToFile:     "../resources/invalidFigiId.json",
+		conceptUuid:    testUuid,
+		uppConcordance: noConcordance,
+		expectedError:  errors.New("is not a valid FIGI Id"),
+	}
+	errorOnDuplicateFigiIds := testStruct{
+		testName:       "errorOnDuplicateFigiIds",
+		pathToFile:     "../resources/duplicateFigiIds.json",
+		conceptUuid:    testUuid,
+		uppConcordance: noConcordance,
+		expectedError:  errors.New("contains duplicate FIGI id values"),
+	}
+	invalidIsinId := testStruct{
+		testName:       "invalidIsinId",
+		pathToFile:     "../resources/invalidIsinId.json",
+		conceptUuid:    testUuid,
+		uppConcordance: noConcordance,
+		expectedError:  errors.New("is not a valid ISIN Id"),
+	}
+	errorOnDuplicateIsinIds := testStruct{
+		testName:       "errorOnDuplicateIsinIds",
+		pathToFile:     "../resources/duplicateIsinIds.json",
+		conceptUuid:    testUuid,
+		uppConcordance: noConcordance,
+		expectedError:  errors.New("contains duplicate ISIN id values"),
+	}
 	noErrorOnNotAllowedConceptType := testStruct{
 		testName:       "noErrorOnNotAllowedConceptType",
 		pathToFile:     "../resources/notAllowedType.json",
@@ -402,6 +739,14 @@ func TestConvertToUppConcordance(t *testing.T) {
 		errorOnDuplicateFactsetIds,
 		handlesMultipleFactsetIds,
 		handlesNoFactsetIds,
+		handlesLeiIds,
+		invalidLeiId,
+		errorOnDuplicateLeiIds,
+		handlesFinancialInstrumentIds,
+		invalidFigiId,
+		errorOnDuplicateFigiIds,
+		invalidIsinId,
+		errorOnDuplicateIsinIds,
 		noErrorOnNotAllowedConceptType,
 		managedLocationIds,
 		managedLocationDuplicateIds,
@@ -418,7 +763,13 @@ func TestConvertToUppConcordance(t *testing.T) {
 		var smartLogicConcept = SmartlogicConcept{}
 		decoder := json.NewDecoder(bytes.NewBufferString(readFile(t, scenario.pathToFile)))
 		err := decoder.Decode(&smartLogicConcept)
-		_, uuid, uppConconcordance, err := convertToUppConcordance(smartLogicConcept, "transaction_id")
+		var uuid string
+		var uppConconcordance UppConcordance
+		_, results, err := convertToUppConcordances(context.Background(), smartLogicConcept, "transaction_id", failFast)
+		if err == nil {
+			assert.Len(t, results, 1, "Scenario: "+scenario.testName+" failed")
+			uuid, uppConconcordance, err = results[0].ConceptUuid, results[0].UppConcordance, results[0].Err
+		}
 		assert.Equal(t, scenario.conceptUuid, uuid, "Scenario: "+scenario.testName+" failed")
 		assert.Equal(t, scenario.uppConcordance, uppConconcordance, "Scenario: "+scenario.testName+" failed. Json output does not match")
 		if scenario.expectedError != nil {
@@ -430,6 +781,241 @@ func TestConvertToUppConcordance(t *testing.T) {
 	}
 }
 
+func TestConvertToUppConcordanceReturnsValidationErrors(t *testing.T) {
+	type testStruct struct {
+		testName      string
+		pathToFile    string
+		expectedError *ValidationError
+	}
+
+	scenarios := []testStruct{
+		{
+			testName:   "invalidFactsetId",
+			pathToFile: "../resources/invalidFactsetId.json",
+			expectedError: &ValidationError{
+				Code:        INVALID_IDENTIFIER,
+				Message:     "Bad Request: Concordance id AbCdEf-gHiJkLMnOpQ-rStUvXyZ-0123456789 is not a valid FACTSET Id",
+				ConceptUuid: testUuid,
+				Authority:   CONCORDANCE_AUTHORITY_FACTSET,
+				Value:       "AbCdEf-gHiJkLMnOpQ-rStUvXyZ-0123456789",
+				Predicate:   "http://www.ft.com/ontology/factsetIdentifier",
+			},
+		},
+		{
+			testName:   "tmeGeneratedUuidEqualConceptUuid",
+			pathToFile: "../resources/tmeGeneratedUuidEqualConceptUuid.json",
+			expectedError: &ValidationError{
+				Code:        SELF_CONCORDANCE,
+				Message:     "Bad Request: Payload from smartlogic has a smartlogic uuid that is the same as the uuid generated from the TME id",
+				ConceptUuid: "e9f4525a-401f-3b23-a68e-e48f314cdce6",
+				Authority:   CONCORDANCE_AUTHORITY_TME,
+				Value:       "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789",
+				Predicate:   "http://www.ft.com/ontology/TMEIdentifier",
+			},
+		},
+		{
+			testName:   "duplicateLeiIds",
+			pathToFile: "../resources/duplicateLeiIds.json",
+			expectedError: &ValidationError{
+				Code:        DUPLICATE_IDENTIFIER,
+				Message:     "Bad Request: Payload from smartlogic contains duplicate LEI id values",
+				ConceptUuid: testUuid,
+				Authority:   CONCORDANCE_AUTHORITY_LEI,
+				Value:       "7LTWFZYICNSX8D621K86",
+				Predicate:   "http://www.ft.com/ontology/leiIdentifier",
+			},
+		},
+		{
+			testName:   "missingTypes",
+			pathToFile: "../resources/noTypes.json",
+			expectedError: &ValidationError{
+				Code:        MISSING_CONCEPT_TYPE,
+				Message:     "Bad Request: Type has not been set for concept: 20db1bd6-59f9-4404-adb5-3165a448f8b0)",
+				ConceptUuid: testUuid,
+			},
+		},
+		{
+			testName:   "membership",
+			pathToFile: "../resources/conceptIsMembership.json",
+			expectedError: &ValidationError{
+				Code:        CONCORDANCE_NOT_SUPPORTED,
+				Message:     "Bad Request: Concept type Membership does not support concordance",
+				ConceptUuid: testUuid,
+			},
+		},
+		{
+			testName:   "notAllowedType",
+			pathToFile: "../resources/notAllowedType.json",
+			expectedError: &ValidationError{
+				Code:        CONCEPT_TYPE_NOT_ALLOWED,
+				Message:     "concept type not allowed: skos:Concept",
+				ConceptUuid: testUuid,
+				ConceptType: "skos:Concept",
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		var smartLogicConcept = SmartlogicConcept{}
+		err := json.NewDecoder(bytes.NewBufferString(readFile(t, scenario.pathToFile))).Decode(&smartLogicConcept)
+		assert.NoError(t, err, "Scenario: "+scenario.testName+" failed")
+		_, results, err := convertToUppConcordances(context.Background(), smartLogicConcept, "transaction_id", failFast)
+		assert.NoError(t, err, "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedError, results[0].Err, "Scenario: "+scenario.testName+" failed")
+		assert.True(t, errors.Is(results[0].Err, &ValidationError{Code: scenario.expectedError.Code}), "Scenario: "+scenario.testName+" failed")
+	}
+	assert.False(t, errors.Is(&ValidationError{Code: MISSING_CONCEPT_TYPE}, errConceptTypeNotAllowed))
+}
+
+func TestConvertToUppConcordanceCollectsAllValidationErrors(t *testing.T) {
+	conceptUuid := "e9f4525a-401f-3b23-a68e-e48f314cdce6"
+	tmePredicate := "http://www.ft.com/ontology/TMEIdentifier"
+	expectedErrors := ValidationErrors{
+		{Code: SELF_CONCORDANCE, Message: "Bad Request: Payload from smartlogic has a smartlogic uuid that is the same as the uuid generated from the TME id", ConceptUuid: conceptUuid, Authority: CONCORDANCE_AUTHORITY_TME, Value: "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789", Predicate: tmePredicate},
+		{Code: INVALID_IDENTIFIER, Message: "Bad Request: Concordance id XXXXX is not a valid TME Id", ConceptUuid: conceptUuid, Authority: CONCORDANCE_AUTHORITY_TME, Value: "XXXXX", Predicate: tmePredicate},
+		{Code: DUPLICATE_IDENTIFIER, Message: "Bad Request: Payload from smartlogic contains duplicate TME id values", ConceptUuid: conceptUuid, Authority: CONCORDANCE_AUTHORITY_TME, Value: "ZyXwVuTsRqPoNmLkJiHgFeDcBa-0987654321", Predicate: tmePredicate},
+		{Code: INVALID_IDENTIFIER, Message: "Bad Request: Concordance id 123456-E is not a valid FACTSET Id", ConceptUuid: conceptUuid, Authority: CONCORDANCE_AUTHORITY_FACTSET, Value: "123456-E", Predicate: "http://www.ft.com/ontology/factsetIdentifier"},
+	}
+
+	var smartLogicConcept = SmartlogicConcept{}
+	err := json.NewDecoder(bytes.NewBufferString(readFile(t, "../resources/multipleInvalidIds.json"))).Decode(&smartLogicConcept)
+	assert.NoError(t, err)
+
+	conceptStatus, _, _, err := convertToUppConcordance(smartLogicConcept.Concepts[0], "transaction_id", collectAll)
+	assert.Equal(t, SYNTACTICALLY_INCORRECT, conceptStatus)
+	assert.Equal(t, expectedErrors, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "4 validation errors: Bad Request: Payload from smartlogic has a smartlogic uuid"))
+
+	conceptStatus, _, _, err = convertToUppConcordance(smartLogicConcept.Concepts[0], "transaction_id", failFast)
+	assert.Equal(t, SYNTACTICALLY_INCORRECT, conceptStatus)
+	assert.Equal(t, expectedErrors[0], err)
+
+	var noTypes = SmartlogicConcept{}
+	err = json.NewDecoder(bytes.NewBufferString(`{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "XXXXX"}]}]}`)).Decode(&noTypes)
+	assert.NoError(t, err)
+	_, _, _, err = convertToUppConcordance(noTypes.Concepts[0], "transaction_id", collectAll)
+	if assert.IsType(t, ValidationErrors{}, err) {
+		assert.Len(t, err, 2)
+		assert.Equal(t, MISSING_CONCEPT_TYPE, err.(ValidationErrors)[0].Code)
+		assert.Equal(t, INVALID_IDENTIFIER, err.(ValidationErrors)[1].Code)
+	}
+}
+
+func TestConvertToUppConcordancesMultipleConcepts(t *testing.T) {
+	var smartLogicConcept = SmartlogicConcept{}
+	err := json.NewDecoder(bytes.NewBufferString(readFile(t, "../resources/multipleConcepts.json"))).Decode(&smartLogicConcept)
+	assert.NoError(t, err)
+
+	_, results, err := convertToUppConcordances(context.Background(), smartLogicConcept, "transaction_id", failFast)
+	assert.NoError(t, err)
+	assert.Equal(t, []ConceptResult{
+		{
+			ConceptUuid: testUuid,
+			Status:      VALID_CONCEPT,
+			UppConcordance: UppConcordance{
+				ConceptUuid: testUuid,
+				Authority:   "Smartlogic",
+				ConcordedIds: []ConcordedId{{
+					Authority:      CONCORDANCE_AUTHORITY_TME,
+					AuthorityValue: "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789",
+					UUID:           "e9f4525a-401f-3b23-a68e-e48f314cdce6",
+				}},
+			},
+		},
+		{
+			ConceptUuid: "95f00e25-9a5f-45ec-8ad8-5607d021c74b",
+			Status:      VALID_CONCEPT,
+			UppConcordance: UppConcordance{
+				ConceptUuid: "95f00e25-9a5f-45ec-8ad8-5607d021c74b",
+				Authority:   "Smartlogic",
+				ConcordedIds: []ConcordedId{{
+					Authority:      CONCORDANCE_AUTHORITY_FACTSET,
+					AuthorityValue: "000D63-E",
+					UUID:           "8d3aba95-02d9-3802-afc0-b99bb9b1139e",
+				}},
+			},
+		},
+	}, results)
+}
+
+func TestConvertToUppConcordancesReportsEachFailedConcept(t *testing.T) {
+	var smartLogicConcept = SmartlogicConcept{}
+	err := json.NewDecoder(bytes.NewBufferString(readFile(t, "../resources/multipleGraphsInList.json"))).Decode(&smartLogicConcept)
+	assert.NoError(t, err)
+
+	_, results, err := convertToUppConcordances(context.Background(), smartLogicConcept, "transaction_id", failFast)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	for _, result := range results {
+		assert.Equal(t, SYNTACTICALLY_INCORRECT, result.Status)
+		assert.Contains(t, result.Err.Error(), "Type has not been set for concept: "+result.ConceptUuid)
+	}
+	assert.Contains(t, failedResultsError(results).Error(), "2 of 2 concepts in smartlogic concept payload could not be processed")
+}
+
+func TestRegisteredAuthorityIsConcorded(t *testing.T) {
+	defaultAuthorities := authorities
+	defer func() { authorities = defaultAuthorities }()
+	authorities = &authorityRegistry{}
+	RegisterAuthority(tmeAuthority)
+	RegisterAuthority(Authority{
+		Name: "Test",
+		Predicates: map[ConceptFamily][]string{
+			EditorialConcept: {"http://www.ft.com/ontology/testIdentifier"},
+		},
+		Validate: func(value string) error {
+			if !strings.HasPrefix(value, "T") {
+				return errors.New("Bad Request: Concordance id " + value + " is not a valid Test Id")
+			}
+			return nil
+		},
+		DeriveUUID:  convertToUuid,
+		OnDuplicate: DuplicateSkip,
+	})
+
+	type testStruct struct {
+		testName             string
+		payload              string
+		expectedConcordances []ConcordedId
+		expectedError        string
+	}
+
+	scenarios := []testStruct{
+		{
+			testName: "registeredAuthorityIsConcordedAfterTme",
+			payload:  `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/testIdentifier": [{"@value": "T1"}, {"@value": "T1"}], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`,
+			expectedConcordances: []ConcordedId{
+				{Authority: CONCORDANCE_AUTHORITY_TME, AuthorityValue: "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789", UUID: "e9f4525a-401f-3b23-a68e-e48f314cdce6"},
+				{Authority: "Test", AuthorityValue: "T1", UUID: convertToUuid("T1")},
+			},
+		},
+		{
+			testName:      "registeredAuthorityValidatorIsApplied",
+			payload:       `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/testIdentifier": [{"@value": "X1"}]}]}`,
+			expectedError: "is not a valid Test Id",
+		},
+		{
+			testName:             "registeredAuthorityPredicateIsOnlyReadForItsFamily",
+			payload:              `{"@graph": [{"@id": "http://www.ft.com/ontology/managedlocation/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Location"], "http://www.ft.com/ontology/testIdentifier": [{"@value": "T1"}]}]}`,
+			expectedConcordances: []ConcordedId{},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		var smartLogicConcept = SmartlogicConcept{}
+		err := json.NewDecoder(bytes.NewBufferString(scenario.payload)).Decode(&smartLogicConcept)
+		assert.NoError(t, err, "Scenario: "+scenario.testName+" failed")
+		_, _, uppConcordance, err := convertToUppConcordance(smartLogicConcept.Concepts[0], "transaction_id", failFast)
+		if scenario.expectedError != "" {
+			assert.Error(t, err, "Scenario: "+scenario.testName+" failed")
+			assert.Contains(t, err.Error(), scenario.expectedError, "Scenario: "+scenario.testName+" failed")
+			continue
+		}
+		assert.NoError(t, err, "Scenario: "+scenario.testName+" failed")
+		assert.Equal(t, scenario.expectedConcordances, uppConcordance.ConcordedIds, "Scenario: "+scenario.testName+" failed")
+	}
+}
+
 func readFile(t *testing.T, fileName string) string {
 	fullMessage, err := ioutil.ReadFile(fileName)
 	assert.NoError(t, err, "Error reading file ")
@@ -0,0 +1,45 @@
+package smartlogic
+
+import "context"
+
+// concordanceSink is where transformed concordances are sent: a concept with concordances is upserted and one without is deleted.
+// Sinks fanning concordances out to several writers also return the outcome of every writer.
+type concordanceSink interface {
+	write(ctx context.Context, uuid string, uppConcordance UppConcordance, tid string) (status, []WriterOutcome, error)
+	delete(ctx context.Context, uuid string, tid string) (status, []WriterOutcome, error)
+}
+
+// httpWriterSink sends concordances to a concordances writer as PUT and DELETE requests to branches/{uuid}
+type httpWriterSink struct {
+	ts      *TransformerService
+	address string
+	// breaker guards concordances-rw-neo4j only; it is nil for the additional writers
+	breaker *circuitBreaker
+}
+
+func (s httpWriterSink) write(ctx context.Context, uuid string, uppConcordance UppConcordance, tid string) (status, []WriterOutcome, error) {
+	reqStatus, err := s.ts.makeWriteRequest(ctx, s, uuid, uppConcordance, tid)
+	return reqStatus, nil, err
+}
+
+func (s httpWriterSink) delete(ctx context.Context, uuid string, tid string) (status, []WriterOutcome, error) {
+	reqStatus, err := s.ts.makeDeleteRequest(ctx, s, uuid, tid)
+	return reqStatus, nil, err
+}
+
+// concordanceSink returns the sink concordances are sent to, which is concordances-rw-neo4j unless another one has been configured,
+// fanning them out to the additional writers if there are any
+func (ts *TransformerService) concordanceSink() concordanceSink {
+	primary := fanOutTarget{name: primaryWriterName, policy: WriterRequired, sink: httpWriterSink{ts: ts, address: ts.writerAddress, breaker: ts.breaker}}
+	if sink, ok := ts.sink.(*kafkaSink); ok {
+		primary = fanOutTarget{name: sink.topic, policy: WriterRequired, sink: sink}
+	}
+	if len(ts.writers) == 0 {
+		return primary.sink
+	}
+	targets := []fanOutTarget{primary}
+	for _, writer := range ts.writers {
+		targets = append(targets, fanOutTarget{name: writer.Name, policy: writer.Policy, sink: httpWriterSink{ts: ts, address: writer.Address}})
+	}
+	return fanOutSink{targets: targets}
+}
@@ -0,0 +1,80 @@
+package smartlogic
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Financial-Times/smartlogic-concordance-transformer/smartlogic"
+
+// traceContext reads and writes the W3C traceparent and tracestate headers, independently of any globally configured propagator
+var traceContext = propagation.TraceContext{}
+
+// startSpan starts a span with the globally registered tracer provider, which does nothing unless an exporter has been configured
+func startSpan(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it as failed when err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// kafkaHeaderCarrier looks headers up regardless of case, as publishers do not agree on the case of traceparent
+type kafkaHeaderCarrier map[string]string
+
+func (c kafkaHeaderCarrier) Get(key string) string {
+	for k, v := range c {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c kafkaHeaderCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// kafkaTraceContext continues the trace of the smartlogic publish when the Kafka message carries a traceparent header
+func kafkaTraceContext(ctx context.Context, headers map[string]string) context.Context {
+	return traceContext.Extract(ctx, kafkaHeaderCarrier(headers))
+}
+
+// requestTraceContext continues the trace of the caller when the HTTP request carries a traceparent header
+func requestTraceContext(req *http.Request) context.Context {
+	return traceContext.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+}
+
+// injectTraceContext adds the traceparent of the current span to a request to concordances-rw-neo4j
+func injectTraceContext(ctx context.Context, request *http.Request) {
+	traceContext.Inject(ctx, propagation.HeaderCarrier(request.Header))
+}
+
+func writerSpanAttributes(uuid string, tid string, method string, reqURL string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("transaction_id", tid),
+		attribute.String("concept.uuid", uuid),
+		attribute.String("http.method", method),
+		attribute.String("http.url", reqURL),
+	}
+}
@@ -0,0 +1,96 @@
+package smartlogic
+
+import (
+	"testing"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestProcessKafkaMessageTracesThroughToWriter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	withConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}]}`
+	noConcordance := `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0", "@type": ["http://www.ft.com/ontology/Brand"]}]}`
+	publishTraceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	publishSpanID := "00f067aa0ba902b7"
+
+	type testStruct struct {
+		scenarioName    string
+		headers         map[string]string
+		body            string
+		statusCode      int
+		expectedSpans   []string
+		expectedTraceID string
+		expectedParent  string
+	}
+
+	scenarios := []testStruct{
+		{
+			scenarioName:    "traceparentContinued",
+			headers:         map[string]string{"X-Request-Id": "tid_trace", "traceparent": "00-" + publishTraceID + "-" + publishSpanID + "-01"},
+			body:            withConcordance,
+			statusCode:      200,
+			expectedSpans:   []string{"decodeSmartlogicPayload", "convertToUppConcordance", "makeWriteRequest", "ProcessKafkaMessage"},
+			expectedTraceID: publishTraceID,
+			expectedParent:  publishSpanID,
+		},
+		{
+			scenarioName:    "traceparentHeaderCaseIgnored",
+			headers:         map[string]string{"X-Request-Id": "tid_trace", "Traceparent": "00-" + publishTraceID + "-" + publishSpanID + "-01"},
+			body:            noConcordance,
+			statusCode:      204,
+			expectedSpans:   []string{"decodeSmartlogicPayload", "convertToUppConcordance", "makeDeleteRequest", "ProcessKafkaMessage"},
+			expectedTraceID: publishTraceID,
+			expectedParent:  publishSpanID,
+		},
+		{
+			scenarioName:  "newTraceWithoutTraceparent",
+			headers:       map[string]string{"X-Request-Id": "tid_trace"},
+			body:          withConcordance,
+			statusCode:    200,
+			expectedSpans: []string{"decodeSmartlogicPayload", "convertToUppConcordance", "makeWriteRequest", "ProcessKafkaMessage"},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		client := &recordingHttpClient{statusCodes: []int{scenario.statusCode}}
+		h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, client), mockConsumer{}, nil)
+
+		err := h.ProcessKafkaMessage(kafka.FTMessage{Headers: scenario.headers, Body: scenario.body})
+		assert.NoError(t, err, "Scenario: "+scenario.scenarioName+" failed")
+
+		spans := recorder.Ended()
+		names := make([]string, 0, len(spans))
+		for _, span := range spans {
+			names = append(names, span.Name())
+		}
+		assert.Equal(t, scenario.expectedSpans, names, "Scenario: "+scenario.scenarioName+" failed")
+
+		root := spans[len(spans)-1]
+		traceID := root.SpanContext().TraceID().String()
+		if scenario.expectedTraceID != "" {
+			assert.Equal(t, scenario.expectedTraceID, traceID, "Scenario: "+scenario.scenarioName+" failed")
+			assert.Equal(t, scenario.expectedParent, root.Parent().SpanID().String(), "Scenario: "+scenario.scenarioName+" failed")
+		} else {
+			assert.False(t, root.Parent().IsValid(), "Scenario: "+scenario.scenarioName+" failed")
+		}
+		for _, span := range spans[:len(spans)-1] {
+			assert.Equal(t, traceID, span.SpanContext().TraceID().String(), "Scenario: "+scenario.scenarioName+" failed")
+			assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID(), "Scenario: "+scenario.scenarioName+" failed")
+		}
+
+		writerSpan := spans[2]
+		assert.Equal(t, trace.SpanKindClient, writerSpan.SpanKind(), "Scenario: "+scenario.scenarioName+" failed")
+		if assert.Len(t, client.headers(), 1, "Scenario: "+scenario.scenarioName+" failed") {
+			assert.Equal(t, "00-"+traceID+"-"+writerSpan.SpanContext().SpanID().String()+"-01", client.headers()[0].Get("traceparent"), "Scenario: "+scenario.scenarioName+" failed")
+		}
+	}
+}
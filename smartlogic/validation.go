@@ -0,0 +1,91 @@
+package smartlogic
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// validationMode decides whether converting a concept stops at its first problem or carries on to report all of them
+type validationMode int
+
+const (
+	failFast validationMode = iota
+	collectAll
+)
+
+// ValidationCode identifies the kind of problem found in a smartlogic payload
+type ValidationCode string
+
+const (
+	MISSING_GRAPH             ValidationCode = "MISSING_GRAPH"
+	INVALID_CONCEPT_ID        ValidationCode = "INVALID_CONCEPT_ID"
+	MISSING_CONCEPT_TYPE      ValidationCode = "MISSING_CONCEPT_TYPE"
+	CONCORDANCE_NOT_SUPPORTED ValidationCode = "CONCORDANCE_NOT_SUPPORTED"
+	INVALID_IDENTIFIER        ValidationCode = "INVALID_IDENTIFIER"
+	SELF_CONCORDANCE          ValidationCode = "SELF_CONCORDANCE"
+	DUPLICATE_IDENTIFIER      ValidationCode = "DUPLICATE_IDENTIFIER"
+	CONCEPT_TYPE_NOT_ALLOWED  ValidationCode = "CONCEPT_TYPE_NOT_ALLOWED"
+)
+
+// ValidationError describes why a smartlogic payload cannot be concorded. Authority, Value and Predicate are only set when the
+// problem lies with a single identifier, and ConceptType when it lies with the type of the concept; Message keeps the wording of
+// the error so that it reads the same in logs and dead letters.
+type ValidationError struct {
+	Code        ValidationCode `json:"code"`
+	Message     string         `json:"message"`
+	ConceptUuid string         `json:"uuid,omitempty"`
+	ConceptType string         `json:"type,omitempty"`
+	Authority   string         `json:"authority,omitempty"`
+	Value       string         `json:"value,omitempty"`
+	Predicate   string         `json:"predicate,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Is matches any ValidationError with the same code, so that errors.Is can tell a kind of problem apart whatever the concept
+func (e *ValidationError) Is(target error) bool {
+	t, ok := target.(*ValidationError)
+	return ok && t.Code == e.Code
+}
+
+// ValidationErrors holds every problem found in a concept when it is validated in collect-all mode
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d validation errors: %s", len(errs), strings.Join(messages, "; "))
+}
+
+func (e *ValidationError) logFields(tid string) log.Fields {
+	fields := log.Fields{"transaction_id": tid, "UUID": e.ConceptUuid, "error_code": string(e.Code)}
+	if e.ConceptType != "" {
+		fields["concept_type"] = e.ConceptType
+	}
+	if e.Authority != "" {
+		fields["authority"] = e.Authority
+		fields["value"] = e.Value
+		fields["predicate"] = e.Predicate
+	}
+	return fields
+}
+
+func newIdentifierError(code ValidationCode, message string, conceptUuid string, authority string, identifier conceptIdentifier) *ValidationError {
+	return &ValidationError{
+		Code:        code,
+		Message:     message,
+		ConceptUuid: conceptUuid,
+		Authority:   authority,
+		Value:       identifier.Value,
+		Predicate:   identifier.Predicate,
+	}
+}
@@ -0,0 +1,154 @@
+package smartlogic
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// conceptWorkerPool processes Kafka messages concurrently while keeping the messages for a concept in the order they were consumed.
+// Every message is assigned to a worker by the uuid of its concept, and a message with several concepts is split between their workers,
+// so the updates of a concept are processed one after another by the same worker while those of different concepts are processed in parallel. Each worker has a bounded queue; while it is full, submit blocks,
+// holding up the consumer.
+type conceptWorkerPool struct {
+	queues []chan func()
+	// queued counts the tasks waiting for a worker, when set
+	queued prometheus.Gauge
+}
+
+func newConceptWorkerPool(workers int, queueSize int, queued prometheus.Gauge) *conceptWorkerPool {
+	pool := &conceptWorkerPool{queues: make([]chan func(), workers), queued: queued}
+	for i := range pool.queues {
+		queue := make(chan func(), queueSize)
+		pool.queues[i] = queue
+		go func() {
+			for task := range queue {
+				pool.observeQueued(-1)
+				task()
+			}
+		}()
+	}
+	return pool
+}
+
+func (p *conceptWorkerPool) observeQueued(delta float64) {
+	if p.queued != nil {
+		p.queued.Add(delta)
+	}
+}
+
+// submit queues task on the worker for key, waiting for room in its queue unless ctx is done first
+func (p *conceptWorkerPool) submit(ctx context.Context, key string, task func()) error {
+	return p.submitTo(ctx, p.partition(key), task)
+}
+
+func (p *conceptWorkerPool) submitTo(ctx context.Context, worker int, task func()) error {
+	queue := p.queues[worker]
+	p.observeQueued(1)
+	select {
+	case queue <- task:
+		return nil
+	case <-ctx.Done():
+		p.observeQueued(-1)
+		return ctx.Err()
+	}
+}
+
+func (p *conceptWorkerPool) partition(key string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(p.queues)))
+}
+
+// payloadPart is the part of a smartlogic payload holding the concepts assigned to a worker
+type payloadPart struct {
+	worker int
+	body   string
+}
+
+// split divides a smartlogic payload into a part per worker holding the concepts assigned to it by their uuid, so that every concept
+// of a payload with several is processed in order with the other updates of that concept. The parts come in the order of their first
+// concept and keep the other members of the payload; a payload with a single part is kept as it is. A payload that cannot be decoded
+// goes whole to the worker of the empty key and fails there.
+func (p *conceptWorkerPool) split(msgBody string) []payloadPart {
+	var payload map[string]json.RawMessage
+	var concepts []json.RawMessage
+	if err := json.Unmarshal([]byte(msgBody), &payload); err != nil {
+		return []payloadPart{{worker: p.partition(""), body: msgBody}}
+	}
+	if err := json.Unmarshal(payload["@graph"], &concepts); err != nil || len(concepts) < 2 {
+		return []payloadPart{{worker: p.partition(partitionKey(msgBody)), body: msgBody}}
+	}
+
+	ids := make([]string, len(concepts))
+	for i, concept := range concepts {
+		var c struct {
+			ID string `json:"@id"`
+		}
+		json.Unmarshal(concept, &c)
+		ids[i] = c.ID
+	}
+	workers, members := p.assign(ids)
+	whole := []payloadPart{{worker: workers[0], body: msgBody}}
+	if len(workers) == 1 {
+		return whole
+	}
+
+	parts := make([]payloadPart, 0, len(workers))
+	for _, worker := range workers {
+		var workerConcepts []json.RawMessage
+		for _, i := range members[worker] {
+			workerConcepts = append(workerConcepts, concepts[i])
+		}
+		graph, err := json.Marshal(workerConcepts)
+		if err != nil {
+			return whole
+		}
+		payload["@graph"] = graph
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return whole
+		}
+		parts = append(parts, payloadPart{worker: worker, body: string(body)})
+	}
+	return parts
+}
+
+// assign groups the concepts with the given @ids by the worker each of them is assigned to, returning the workers in the order of their
+// first concept and the indexes of the concepts of every worker
+func (p *conceptWorkerPool) assign(ids []string) ([]int, map[int][]int) {
+	var workers []int
+	members := map[int][]int{}
+	for i, id := range ids {
+		worker := p.partition(conceptKey(id))
+		if _, found := members[worker]; !found {
+			workers = append(workers, worker)
+		}
+		members[worker] = append(members[worker], i)
+	}
+	return workers, members
+}
+
+// partitionKey is the uuid of the first concept in a smartlogic payload, or its @id when that is not a concept uri.
+// Payloads that cannot be decoded have an empty key; they fail whichever worker processes them.
+func partitionKey(msgBody string) string {
+	var payload struct {
+		Concepts []struct {
+			ID string `json:"@id"`
+		} `json:"@graph"`
+	}
+	if err := json.Unmarshal([]byte(msgBody), &payload); err != nil || len(payload.Concepts) == 0 {
+		return ""
+	}
+	return conceptKey(payload.Concepts[0].ID)
+}
+
+// conceptKey is the uuid of a concept, or its @id when that is not a concept uri
+func conceptKey(id string) string {
+	if uuid, _ := extractUuidAndConcordanceAuthority(id); uuid != "" {
+		return uuid
+	}
+	return id
+}
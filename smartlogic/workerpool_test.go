@@ -0,0 +1,180 @@
+package smartlogic
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowHttpClient answers every request with 200 after a random delay of a few milliseconds, shuffling the order in which workers finish
+func slowHttpClient() *recordingHttpClient {
+	return &recordingHttpClient{respond: func(req *http.Request) (int, error) {
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		return http.StatusOK, nil
+	}}
+}
+
+func TestConceptWorkerPoolKeepsConceptOrder(t *testing.T) {
+	pool := newConceptWorkerPool(4, 2, nil)
+	keys := []string{"20db1bd6-59f9-4404-adb5-3165a448f8b0", "c372ffba-7a7f-11e6-aca9-d6ece9a77557", "95f00e25-9a5f-45ec-8ad8-5607d021c74b"}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	processed := map[string][]int{}
+	for i := 0; i < 60; i++ {
+		key, seq := keys[i%len(keys)], i
+		wg.Add(1)
+		err := pool.submit(context.Background(), key, func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(rand.Intn(2)) * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			processed[key] = append(processed[key], seq)
+		})
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		assert.Len(t, processed[key], 20, "Key "+key)
+		for i := 1; i < len(processed[key]); i++ {
+			assert.True(t, processed[key][i-1] < processed[key][i], "Key "+key+" processed out of order")
+		}
+	}
+}
+
+func TestConceptWorkerPoolProcessesConceptsInParallel(t *testing.T) {
+	pool := newConceptWorkerPool(2, 1, nil)
+	blockedKey, otherKey := "a", ""
+	for i := 0; otherKey == ""; i++ {
+		if key := fmt.Sprint(i); pool.partition(key) != pool.partition(blockedKey) {
+			otherKey = key
+		}
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	assert.NoError(t, pool.submit(context.Background(), blockedKey, func() { <-release }))
+
+	done := make(chan struct{})
+	assert.NoError(t, pool.submit(context.Background(), otherKey, func() { close(done) }))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("A concept on another worker was held up by a blocked concept")
+	}
+}
+
+func TestConceptWorkerPoolBlocksWhileQueueIsFull(t *testing.T) {
+	pool := newConceptWorkerPool(2, 1, nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assert.NoError(t, pool.submit(context.Background(), "a", func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	assert.NoError(t, pool.submit(context.Background(), "a", func() {}), "The queue should have room for one message")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pool.submit(ctx, "a", func() {}), "Submit should block while the queue is full")
+
+	close(release)
+	assert.NoError(t, pool.submit(context.Background(), "a", func() {}))
+}
+
+func TestPartitionKey(t *testing.T) {
+	type testStruct struct {
+		scenarioName string
+		body         string
+		expectedKey  string
+	}
+
+	scenarios := []testStruct{
+		{scenarioName: "thingUri", body: `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0"}]}`, expectedKey: "20db1bd6-59f9-4404-adb5-3165a448f8b0"},
+		{scenarioName: "locationUri", body: `{"@graph": [{"@id": "http://www.ft.com/ontology/managedlocation/c372ffba-7a7f-11e6-aca9-d6ece9a77557"}]}`, expectedKey: "c372ffba-7a7f-11e6-aca9-d6ece9a77557"},
+		{scenarioName: "firstConceptOfMany", body: `{"@graph": [{"@id": "http://www.ft.com/thing/20db1bd6-59f9-4404-adb5-3165a448f8b0"}, {"@id": "http://www.ft.com/thing/c372ffba-7a7f-11e6-aca9-d6ece9a77557"}]}`, expectedKey: "20db1bd6-59f9-4404-adb5-3165a448f8b0"},
+		{scenarioName: "invalidUri", body: `{"@graph": [{"@id": "http://www.ft.com/thing/not-a-uuid"}]}`, expectedKey: "http://www.ft.com/thing/not-a-uuid"},
+		{scenarioName: "emptyGraph", body: `{"@graph": []}`, expectedKey: ""},
+		{scenarioName: "invalidJson", body: `{"@graph": [`, expectedKey: ""},
+	}
+
+	for _, scenario := range scenarios {
+		assert.Equal(t, scenario.expectedKey, partitionKey(scenario.body), "Scenario: "+scenario.scenarioName+" failed")
+	}
+}
+
+func TestConceptWorkerPoolSplitsPayloadsByConcept(t *testing.T) {
+	pool := newConceptWorkerPool(4, 1, nil)
+	uuids := []string{"20db1bd6-59f9-4404-adb5-3165a448f8b0", "c372ffba-7a7f-11e6-aca9-d6ece9a77557", "95f00e25-9a5f-45ec-8ad8-5607d021c74b", "e9f4525a-401f-3b23-a68e-e48f314cdce6"}
+	var otherWorker []string
+	for _, uuid := range uuids[1:] {
+		if pool.partition(uuid) != pool.partition(uuids[0]) {
+			otherWorker = append(otherWorker, uuid)
+		}
+	}
+	if !assert.NotEmpty(t, otherWorker, "The test needs concepts on different workers") {
+		return
+	}
+	concept := func(uuid string) string {
+		return `{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"]}`
+	}
+
+	body := `{"@context": {"ft": "http://www.ft.com/ontology/"}, "@graph": [` + concept(uuids[0]) + `, ` + concept(otherWorker[0]) + `]}`
+	parts := pool.split(body)
+	if assert.Len(t, parts, 2, "A payload with concepts for two workers should be split in two") {
+		assert.Equal(t, pool.partition(uuids[0]), parts[0].worker)
+		assert.JSONEq(t, `{"@context": {"ft": "http://www.ft.com/ontology/"}, "@graph": [`+concept(uuids[0])+`]}`, parts[0].body)
+		assert.Equal(t, pool.partition(otherWorker[0]), parts[1].worker)
+		assert.JSONEq(t, `{"@context": {"ft": "http://www.ft.com/ontology/"}, "@graph": [`+concept(otherWorker[0])+`]}`, parts[1].body)
+	}
+
+	sameWorker := `{"@graph": [` + concept(uuids[0]) + `, ` + concept(uuids[0]) + `]}`
+	assert.Equal(t, []payloadPart{{worker: pool.partition(uuids[0]), body: sameWorker}}, pool.split(sameWorker), "A payload for a single worker should be kept as it is")
+	assert.Equal(t, []payloadPart{{worker: pool.partition(""), body: `{"@graph": [`}}, pool.split(`{"@graph": [`), "A payload that cannot be decoded should be kept whole")
+}
+
+func TestProcessKafkaMessageWithConcurrencyKeepsConceptOrder(t *testing.T) {
+	client := slowHttpClient()
+	h := NewHandler(NewTransformerService(TOPIC, WRITER_ADDRESS, client), mockConsumer{}, nil, WithConcurrency(4, 2))
+
+	uuids := []string{"20db1bd6-59f9-4404-adb5-3165a448f8b0", "c372ffba-7a7f-11e6-aca9-d6ece9a77557", "95f00e25-9a5f-45ec-8ad8-5607d021c74b"}
+	concept := func(uuid string, method string) string {
+		if method == "PUT" {
+			return `{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"], "http://www.ft.com/ontology/TMEIdentifier": [{"@value": "AbCdEfgHiJkLMnOpQrStUvWxYz-0123456789"}]}`
+		}
+		return `{"@id": "http://www.ft.com/thing/` + uuid + `", "@type": ["http://www.ft.com/ontology/Brand"]}`
+	}
+	expected := map[string][]string{}
+	for i := 0; i < 30; i++ {
+		uuid := uuids[i%len(uuids)]
+		method := "DELETE"
+		if (i/len(uuids))%2 == 0 {
+			method = "PUT"
+		}
+		expected[uuid] = append(expected[uuid], method)
+		body := `{"@graph": [` + concept(uuid, method) + `]}`
+		if i%5 == 0 {
+			// Every concept of a payload with several is kept in order with the other updates of that concept, not just the first one
+			other := uuids[(i+1)%len(uuids)]
+			expected[other] = append(expected[other], "PUT")
+			body = `{"@graph": [` + concept(uuid, method) + `, ` + concept(other, "PUT") + `]}`
+		}
+		assert.NoError(t, h.ProcessKafkaMessage(kafka.FTMessage{Headers: map[string]string{"X-Request-Id": "tid_concurrent"}, Body: body}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, h.AwaitDrained(ctx), "Draining should wait for the queued messages")
+	for _, uuid := range uuids {
+		assert.Equal(t, expected[uuid], client.methods(uuid), "Concept "+uuid+" written out of order")
+	}
+}
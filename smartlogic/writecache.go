@@ -0,0 +1,118 @@
+package smartlogic
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// writeCache remembers a content hash of the concordance last successfully written for the most recently written concepts,
+// so that a smartlogic edit that does not change the concordance does not cause another write. Entries expire after ttl so that
+// the writer is still brought back in line with smartlogic if it changed by other means.
+type writeCache struct {
+	sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type writeCacheEntry struct {
+	uuid      string
+	hash      string
+	writtenAt time.Time
+}
+
+func newWriteCache(size int, ttl time.Duration) *writeCache {
+	return &writeCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// unchanged reports whether the concordance is the one last written for its concept, counting the write it saves
+func (c *writeCache) unchanged(uuid string, uppConcordance UppConcordance) bool {
+	if c == nil {
+		return false
+	}
+	hash := concordanceHash(uppConcordance)
+	c.Lock()
+	defer c.Unlock()
+	element, found := c.entries[uuid]
+	if !found {
+		return false
+	}
+	entry := element.Value.(*writeCacheEntry)
+	if c.ttl > 0 && c.now().Sub(entry.writtenAt) > c.ttl {
+		c.order.Remove(element)
+		delete(c.entries, uuid)
+		return false
+	}
+	if entry.hash != hash {
+		return false
+	}
+	c.order.MoveToFront(element)
+	writesSkipped.Inc()
+	return true
+}
+
+// written records the concordance that was successfully written for a concept, evicting the least recently used concept when full
+func (c *writeCache) written(uuid string, uppConcordance UppConcordance) {
+	if c == nil {
+		return
+	}
+	hash := concordanceHash(uppConcordance)
+	c.Lock()
+	defer c.Unlock()
+	if element, found := c.entries[uuid]; found {
+		entry := element.Value.(*writeCacheEntry)
+		entry.hash = hash
+		entry.writtenAt = c.now()
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[uuid] = c.order.PushFront(&writeCacheEntry{uuid: uuid, hash: hash, writtenAt: c.now()})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*writeCacheEntry).uuid)
+	}
+}
+
+// forget drops a concept whose last write failed, as what the writer holds for it is no longer known
+func (c *writeCache) forget(uuid string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if element, found := c.entries[uuid]; found {
+		c.order.Remove(element)
+		delete(c.entries, uuid)
+	}
+}
+
+// concordanceHash only covers what is written to concordances-rw-neo4j, ignoring the order the identifiers were listed in by smartlogic
+func concordanceHash(uppConcordance UppConcordance) string {
+	concordedIds := make([]ConcordedId, len(uppConcordance.ConcordedIds))
+	copy(concordedIds, uppConcordance.ConcordedIds)
+	sort.Slice(concordedIds, func(i, j int) bool {
+		if concordedIds[i].Authority != concordedIds[j].Authority {
+			return concordedIds[i].Authority < concordedIds[j].Authority
+		}
+		return concordedIds[i].UUID < concordedIds[j].UUID
+	})
+	uppConcordance.ConcordedIds = concordedIds
+
+	body, _ := json.Marshal(uppConcordance)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package smartlogic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteCache(t *testing.T) {
+	now := time.Date(2019, 11, 4, 10, 0, 0, 0, time.UTC)
+	cache := newWriteCache(2, time.Hour)
+	cache.now = func() time.Time { return now }
+	skipped := testutil.ToFloat64(writesSkipped)
+
+	concordance := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedTmeId, concordedFactsetId}}
+	reordered := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedFactsetId, concordedTmeId}}
+	changed := UppConcordance{ConceptUuid: testUuid, Authority: "Smartlogic", ConcordedIds: []ConcordedId{concordedTmeId}}
+
+	assert.False(t, cache.unchanged(testUuid, concordance), "Unknown concept should be written")
+	cache.written(testUuid, concordance)
+	assert.True(t, cache.unchanged(testUuid, concordance), "Same concordance should be skipped")
+	assert.True(t, cache.unchanged(testUuid, reordered), "Reordered concordance should be skipped")
+	assert.False(t, cache.unchanged(testUuid, changed), "Changed concordance should be written")
+	assert.Equal(t, float64(2), testutil.ToFloat64(writesSkipped)-skipped)
+
+	now = now.Add(61 * time.Minute)
+	assert.False(t, cache.unchanged(testUuid, concordance), "Expired concordance should be written")
+
+	cache.written(testUuid, concordance)
+	cache.written("uuid-2", changed)
+	assert.True(t, cache.unchanged(testUuid, concordance))
+	cache.written("uuid-3", changed)
+	assert.False(t, cache.unchanged("uuid-2", changed), "Least recently used concept should be evicted")
+	assert.True(t, cache.unchanged(testUuid, concordance))
+	assert.True(t, cache.unchanged("uuid-3", changed))
+
+	cache.forget(testUuid)
+	assert.False(t, cache.unchanged(testUuid, concordance), "Forgotten concept should be written")
+}
+
+func TestMakeRelevantRequestSkipsUnchangedConcordance(t *testing.T) {
+	withConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{concordedTmeId}}
+	noConcordance := UppConcordance{ConceptUuid: testUuid, ConcordedIds: []ConcordedId{}}
+
+	client := &recordingHttpClient{
+		statusCodes: []int{200, 503, 200, 204},
+		errs:        []error{nil, nil, nil, nil},
+	}
+	ts := NewTransformerService("", writerUrl, client, WithWriteCache(10, time.Hour))
+
+	reqStatus, _, err := ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_test")
+	assert.NoError(t, err)
+	assert.Equal(t, VALID_CONCEPT, reqStatus)
+
+	reqStatus, _, err = ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_test")
+	assert.NoError(t, err)
+	assert.Equal(t, UNCHANGED, reqStatus)
+	assert.Equal(t, 1, client.calls(), "Unchanged concordance should not be sent to the writer")
+
+	reqStatus, _, err = ts.makeRelevantRequest(context.Background(), testUuid, noConcordance, "tid_test")
+	assert.Equal(t, errors.New("Internal Error: Delete request to writer returned unexpected status: 503"), err)
+	assert.Equal(t, INTERNAL_ERROR, reqStatus)
+
+	reqStatus, _, err = ts.makeRelevantRequest(context.Background(), testUuid, withConcordance, "tid_test")
+	assert.NoError(t, err)
+	assert.Equal(t, VALID_CONCEPT, reqStatus, "Concordance should be written again after a failed write")
+
+	reqStatus, _, err = ts.makeRelevantRequest(context.Background(), testUuid, noConcordance, "tid_test")
+	assert.NoError(t, err)
+	assert.Equal(t, NO_CONTENT, reqStatus)
+	assert.Equal(t, 4, client.calls())
+}
@@ -0,0 +1,210 @@
+package smartlogic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WriterAuthConfig configures how requests to the writers, including their __gtg, are authenticated.
+// Any combination of an API key, basic auth and a client certificate can be used; the files are reloaded when they change.
+type WriterAuthConfig struct {
+	// APIKeyHeader is the header APIKey is sent in
+	APIKeyHeader string
+	APIKey       string
+	// BasicAuthFile holds the basic auth credentials as username:password
+	BasicAuthFile string
+	// ClientCertFile and ClientKeyFile hold the PEM certificate and key presented to the writers for mTLS
+	ClientCertFile string
+	ClientKeyFile  string
+	// CAFile holds the PEM certificates of the CAs that the certificates of the writers are verified against, instead of the system roots
+	CAFile string
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration
+}
+
+// Mechanisms lists the authentication mechanisms in use, for logging
+func (c WriterAuthConfig) Mechanisms() []string {
+	var mechanisms []string
+	if c.APIKey != "" {
+		mechanisms = append(mechanisms, "api-key")
+	}
+	if c.BasicAuthFile != "" {
+		mechanisms = append(mechanisms, "basic")
+	}
+	if c.ClientCertFile != "" {
+		mechanisms = append(mechanisms, "mtls")
+	}
+	if c.CAFile != "" {
+		mechanisms = append(mechanisms, "custom-ca")
+	}
+	return mechanisms
+}
+
+func (c WriterAuthConfig) files() []string {
+	var files []string
+	for _, file := range []string{c.BasicAuthFile, c.ClientCertFile, c.ClientKeyFile, c.CAFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// WriterAuthClient authenticates every request to the writers before sending it. The secret files are checked for changes at most
+// every ReloadInterval; credentials that fail to load are logged and the previous ones kept, so that a half-written secret does not
+// break requests mid-rotation.
+type WriterAuthClient struct {
+	config    WriterAuthConfig
+	base      *http.Client
+	lock      sync.Mutex
+	client    *http.Client
+	username  string
+	password  string
+	modTimes  map[string]time.Time
+	checkedAt time.Time
+	now       func() time.Time
+}
+
+// NewWriterAuthClient returns a client sending requests with base once authenticated as configured. Client certificates and CAs
+// need base to use an *http.Transport, which is cloned with their TLS configuration.
+func NewWriterAuthClient(base *http.Client, config WriterAuthConfig) (*WriterAuthClient, error) {
+	if config.APIKey != "" && config.APIKeyHeader == "" {
+		config.APIKeyHeader = "X-Api-Key"
+	}
+	if (config.ClientCertFile == "") != (config.ClientKeyFile == "") {
+		return nil, errors.New("both a client certificate and a client key file are needed for mTLS")
+	}
+	if _, ok := base.Transport.(*http.Transport); !ok && (config.ClientCertFile != "" || config.CAFile != "") {
+		return nil, errors.New("client certificates and CAs need an *http.Transport")
+	}
+	c := &WriterAuthClient{config: config, base: base, client: base, now: time.Now}
+	modTimes, err := c.statFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTimes); err != nil {
+		return nil, err
+	}
+	c.checkedAt = c.now()
+	return c, nil
+}
+
+// Do adds the credentials to a copy of the request and sends it
+func (c *WriterAuthClient) Do(req *http.Request) (*http.Response, error) {
+	client, username, password := c.current()
+	req = req.Clone(req.Context())
+	if c.config.APIKey != "" {
+		req.Header.Set(c.config.APIKeyHeader, c.config.APIKey)
+	}
+	if c.config.BasicAuthFile != "" {
+		req.SetBasicAuth(username, password)
+	}
+	return client.Do(req)
+}
+
+// current returns the client and basic auth credentials to use, reloading them first if a file has changed since they were loaded
+func (c *WriterAuthClient) current() (*http.Client, string, string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.config.ReloadInterval > 0 && c.now().Sub(c.checkedAt) >= c.config.ReloadInterval {
+		c.checkedAt = c.now()
+		c.reloadIfChanged()
+	}
+	return c.client, c.username, c.password
+}
+
+func (c *WriterAuthClient) reloadIfChanged() {
+	modTimes, err := c.statFiles()
+	if err != nil {
+		log.WithError(err).Error("Cannot check the writer credentials for changes; keeping the current ones")
+		observeWriterAuthReload(err)
+		return
+	}
+	changed := false
+	for file, modTime := range modTimes {
+		if !modTime.Equal(c.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	previous := c.client
+	err = c.load(modTimes)
+	observeWriterAuthReload(err)
+	if err != nil {
+		log.WithError(err).Error("Cannot reload the writer credentials; keeping the current ones")
+		return
+	}
+	log.WithField("mechanisms", strings.Join(c.config.Mechanisms(), ",")).Info("Reloaded the writer credentials")
+	if previous != c.client && previous != c.base {
+		previous.Transport.(*http.Transport).CloseIdleConnections()
+	}
+}
+
+// load reads every file, only replacing the credentials in use once all of them have been read successfully
+func (c *WriterAuthClient) load(modTimes map[string]time.Time) error {
+	var username, password string
+	if c.config.BasicAuthFile != "" {
+		content, err := ioutil.ReadFile(c.config.BasicAuthFile)
+		if err != nil {
+			return err
+		}
+		credentials := strings.SplitN(strings.TrimSpace(string(content)), ":", 2)
+		if len(credentials) != 2 || credentials[0] == "" {
+			return fmt.Errorf("basic auth file %s does not hold username:password", c.config.BasicAuthFile)
+		}
+		username, password = credentials[0], credentials[1]
+	}
+
+	client := c.base
+	if c.config.ClientCertFile != "" || c.config.CAFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if c.config.ClientCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(c.config.ClientCertFile, c.config.ClientKeyFile)
+			if err != nil {
+				return err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if c.config.CAFile != "" {
+			pem, err := ioutil.ReadFile(c.config.CAFile)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("CA file %s holds no PEM certificates", c.config.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport := c.base.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client = &http.Client{Transport: transport, Timeout: c.base.Timeout, CheckRedirect: c.base.CheckRedirect, Jar: c.base.Jar}
+	}
+
+	c.client, c.username, c.password, c.modTimes = client, username, password, modTimes
+	return nil
+}
+
+func (c *WriterAuthClient) statFiles() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, file := range c.config.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
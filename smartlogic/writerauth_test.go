@@ -0,0 +1,190 @@
+package smartlogic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// authRecordingServer records the credentials every request to it was made with
+type authRecordingServer struct {
+	sync.Mutex
+	apiKeys     []string
+	users       []string
+	clientNames []string
+}
+
+func (s *authRecordingServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.apiKeys = append(s.apiKeys, req.Header.Get("X-Api-Key"))
+	user, password, _ := req.BasicAuth()
+	s.users = append(s.users, user+":"+password)
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		s.clientNames = append(s.clientNames, req.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+func writeSecret(t *testing.T, file string, content string, modTime time.Time) {
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	assert.NoError(t, os.Chtimes(file, modTime, modTime))
+}
+
+func TestWriterAuthClientHeaders(t *testing.T) {
+	recorder := &authRecordingServer{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "writerauth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Add(-time.Hour)
+	basicAuthFile := filepath.Join(dir, "basic-auth")
+	writeSecret(t, basicAuthFile, "transformer:first\n", start)
+	client, err := NewWriterAuthClient(&http.Client{}, WriterAuthConfig{APIKey: "secret-key", BasicAuthFile: basicAuthFile, ReloadInterval: time.Minute})
+	assert.NoError(t, err)
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	ts := NewTransformerService(TOPIC, server.URL+"/", client)
+	send := func() {
+		req, _ := http.NewRequest("PUT", server.URL+"/branches/"+testUuid, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Empty(t, req.Header, "The request of the caller should not be modified")
+	}
+
+	send()
+	assert.NoError(t, ts.probeWriter(), "The __gtg probe should be authenticated too")
+
+	writeSecret(t, basicAuthFile, "transformer:second", start.Add(time.Second))
+	send()
+	now = now.Add(time.Minute)
+	send()
+
+	writeSecret(t, basicAuthFile, "half-written", start.Add(2*time.Second))
+	now = now.Add(time.Minute)
+	send()
+
+	assert.Equal(t, []string{"secret-key", "secret-key", "secret-key", "secret-key", "secret-key"}, recorder.apiKeys)
+	assert.Equal(t, []string{"transformer:first", "transformer:first", "transformer:first", "transformer:second", "transformer:second"}, recorder.users,
+		"Credentials should be reloaded once the reload interval has passed, keeping the current ones when the new ones are invalid")
+}
+
+func TestWriterAuthClientConfig(t *testing.T) {
+	_, err := NewWriterAuthClient(&http.Client{Transport: &http.Transport{}}, WriterAuthConfig{ClientCertFile: "client.pem"})
+	assert.EqualError(t, err, "both a client certificate and a client key file are needed for mTLS")
+
+	_, err = NewWriterAuthClient(&http.Client{}, WriterAuthConfig{CAFile: "ca.pem"})
+	assert.EqualError(t, err, "client certificates and CAs need an *http.Transport")
+
+	_, err = NewWriterAuthClient(&http.Client{}, WriterAuthConfig{BasicAuthFile: "does-not-exist"})
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"api-key", "mtls", "custom-ca"}, WriterAuthConfig{APIKey: "key", ClientCertFile: "c", ClientKeyFile: "k", CAFile: "ca"}.Mechanisms())
+}
+
+func TestWriterAuthClientMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writerauth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := newTestCertificate(t, "test-ca", nil, nil)
+	serverCert, serverKey := newTestCertificate(t, "127.0.0.1", ca, caKey)
+	firstCert, firstKey := newTestCertificate(t, "transformer-1", ca, caKey)
+	secondCert, secondKey := newTestCertificate(t, "transformer-2", ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	recorder := &authRecordingServer{}
+	server := httptest.NewUnstartedServer(recorder)
+	server.TLS = &tls.Config{
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	start := time.Now().Add(-time.Hour)
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writeSecret(t, caFile, encodeCertificate(ca), start)
+	writeSecret(t, certFile, encodeCertificate(firstCert), start)
+	writeSecret(t, keyFile, encodeKey(t, firstKey), start)
+
+	base := &http.Client{Transport: &http.Transport{}}
+	_, err = base.Get(server.URL)
+	assert.Error(t, err, "The certificate of the server should not be trusted without the CA")
+
+	client, err := NewWriterAuthClient(base, WriterAuthConfig{ClientCertFile: certFile, ClientKeyFile: keyFile, CAFile: caFile, ReloadInterval: time.Minute})
+	assert.NoError(t, err)
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	ts := NewTransformerService(TOPIC, server.URL+"/", client)
+	assert.NoError(t, ts.probeWriter())
+
+	writeSecret(t, certFile, encodeCertificate(secondCert), start.Add(time.Second))
+	writeSecret(t, keyFile, encodeKey(t, secondKey), start.Add(time.Second))
+	now = now.Add(time.Minute)
+	assert.NoError(t, ts.probeWriter())
+
+	assert.Equal(t, []string{"transformer-1", "transformer-2"}, recorder.clientNames, "The rotated client certificate should be presented after a reload")
+}
+
+// newTestCertificate creates a certificate for name, self-signed when parent is nil
+func newTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert, key
+}
+
+func encodeCertificate(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func encodeKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}